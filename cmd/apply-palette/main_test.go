@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/status"
+)
+
+// runMainEnv is set in the environment of the test binary when it is run as the command
+const runMainEnv = "APPLY_PALETTE_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(status.OK)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs the command with `args` in a subprocess and returns its exit code and stderr
+func run(t *testing.T, args ...string) (int, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode(), stderr.Bytes()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stderr.Bytes()
+}
+
+// writeImage writes a small gradient PNG to `path`
+func writeImage(t *testing.T, path string) {
+	t.Helper()
+	i := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			i.SetRGBA(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, i); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	img, missing := filepath.Join(dir, "in.png"), filepath.Join(dir, "missing.png")
+	writeImage(t, img)
+	pal := filepath.Join(dir, "palette.txt")
+	if err := ioutil.WriteFile(pal, []byte("#000000\n#ffffff\n#ff0000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out", "{{basename .Path}}")
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"ok", []string{"-palette", pal, "-out-png", out, img}, status.OK},
+		{"extracted palette", []string{"-k", "2", "-seed", "1", "-out-gif", out + ".gif", img}, status.OK},
+		{"some images missing", []string{"-palette", pal, "-out-png", out, img, missing}, status.Partial},
+		{"template error", []string{"-palette", pal, "-out-png", "{{index .Palette 10}}", img}, status.Partial},
+		{"write error", []string{"-palette", pal, "-out-png", filepath.Join(img, "out.png"), img}, status.Partial},
+		{"invalid flags", []string{"-overwrite", "-no-clobber", img}, status.Usage},
+		{"no workers", []string{"-palette", pal, "-p", "0", img}, status.Usage},
+		{"no images", []string{"-palette", pal}, status.Usage},
+		{"two palettes", []string{"-palette", pal, "-palette-image", img, img}, status.Usage},
+		{"all images missing", []string{"-palette", pal, "-out-png", out, missing}, status.Failure},
+		{"palette missing", []string{"-palette", filepath.Join(dir, "missing.txt"), img}, status.Failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, stderr := run(t, append([]string{"-quiet"}, tt.args...)...); code != tt.want {
+				t.Errorf("apply-palette %q exited with %d, want %d\n%s", tt.args, code, tt.want, stderr)
+			}
+		})
+	}
+}
@@ -2,75 +2,113 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	_ "image/gif"
-	_ "image/jpeg"
 	"image/png"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"os/signal"
 	"runtime"
 	"sort"
+	"strconv"
 	"sync"
-	"text/template"
 
 	"github.com/kballard/go-shellquote"
+	flagvarEnum "github.com/sgreben/flagvar"
 	flagvarGlob "github.com/sgreben/flagvar/glob"
 	"github.com/sgreben/flagvar/template"
+	"github.com/sgreben/image-palette-tools/pkg/diskcache"
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+	"github.com/sgreben/image-palette-tools/pkg/output"
 	"github.com/sgreben/image-palette-tools/pkg/palette"
+	"github.com/sgreben/image-palette-tools/pkg/progress"
+	"github.com/sgreben/image-palette-tools/pkg/schema"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+	"github.com/sgreben/image-palette-tools/pkg/templates"
 )
 
-type paletteJSON struct {
-	Path    string   `json:"path"`
-	Palette []string `json:"palette"`
-}
+var (
+	kImage             int
+	kPalette           int
+	outPngCluster      = flagvar.Template{Root: templateSettings}
+	outPngSingle       = flagvar.Template{Root: templateSettings}
+	inJSON             = flagvar.Template{Root: templateSettings}
+	outClusterJSON     = flagvar.Template{Root: templateSettings}
+	outJSON            = flagvar.Template{Root: templateSettings}
+	outShell           = flagvar.Template{Root: templateSettings}
+	outReport          = flagvar.Template{Root: templateSettings}
+	globSelect         flagvarGlob.Glob
+	outColorSize       int
+	maxParallel        int
+	outReportThumbSize int
+	colorSortOrder     = palette.OrderLHS
 
-type pathPalette struct {
-	Path    string
-	Palette []color.RGBA
-}
+	templateSettings = templates.New()
 
-var (
-	kImage         int
-	kPalette       int
-	outPngCluster  = flagvar.Template{Root: templateSettings}
-	outPngSingle   = flagvar.Template{Root: templateSettings}
-	inJSON         = flagvar.Template{Root: templateSettings}
-	outClusterJSON = flagvar.Template{Root: templateSettings}
-	outJSON        = flagvar.Template{Root: templateSettings}
-	outShell       = flagvar.Template{Root: templateSettings}
-	globSelect     flagvarGlob.Glob
-	outColorSize   int
-	maxParallel    int
-	colorSortOrder = palette.LessLHS
-
-	templateSettings = template.New("").Funcs(map[string]interface{}{
-		"abs":      func(s string) (string, error) { return filepath.Abs(s) },
-		"basename": func(s string) string { return filepath.Base(s) },
-		"dirname":  func(s string) string { return filepath.Dir(s) },
-		"ext":      func(s string) string { return filepath.Ext(s) },
-		"html":     func(c color.RGBA) string { return html(c) },
-	})
-	colorCache    = palette.NewColorCache(512)
-	paletteCache  = palette.NewPaletteCache(512)
+	ctx           = context.Background()
+	cancel        context.CancelFunc
+	cacheDir      string
+	cacheClear    bool
+	diskCache     *diskcache.Cache
+	include       = flagvarGlob.Globs{Separators: &[]rune{'/'}}
+	exclude       = flagvarGlob.Globs{Separators: &[]rune{'/'}}
+	symlinks      = flagvarEnum.Enum{Choices: input.SymlinkPolicies, Value: input.SymlinksFiles}
+	walk          input.WalkOptions
 	printBuffer   = 1024
+	print         chan interface{}
 	clusterBuffer = 16
+
+	quiet        bool
+	progressMode = flagvarEnum.Enum{Choices: progress.Modes, Value: progress.ModeAuto}
+	tracker      *progress.Tracker
+	logFormat    = flagvarEnum.Enum{Choices: logging.Formats, Value: logging.FormatText}
+	outErrors    string
+	errorsFile   *os.File
+	errorsEnc    *json.Encoder
+	errorsMu     sync.Mutex
+	errs         status.Errors
+	failFast     bool
+	maxErrors    int
+	overwrite    bool
+	skipExisting bool
+	noClobber    bool
+	outputs      output.Writer
+	failed       []string
+	failedMu     sync.Mutex
+	clustered    bool
+
+	noColorManagement bool
+	decodeOptions     input.DecodeOptions
+	streamAbove       int
+
+	algorithm      = flagvarEnum.Enum{Choices: palette.Algorithms, Value: palette.AlgorithmKmeansPP}
+	colorSpace     = flagvarEnum.Enum{Choices: palette.ColorSpaces, Value: "hsl"}
+	sample         int
+	seed           int64
+	workers        int
+	extractOptions palette.Options
+	clusterOptions palette.Options
+
+	version = "dev"
+	tool    = &schema.Tool{Name: "cluster-by-palette", Version: version}
 )
 
 const defaultInJSON = "{{.Path}}.palette-{{.K}}.json"
 const defaultOutJSON = defaultInJSON
 
 func init() {
-	log.SetOutput(os.Stderr)
 	flag.IntVar(&kImage, "n", 5, "number of image clusters to make")
 	flag.IntVar(&kPalette, "k", 4, "palette size")
 	flag.IntVar(&maxParallel, "p", runtime.GOMAXPROCS(0), "number of images to process in parallel")
-	flag.Var(&globSelect, "glob", "glob expression matching image files to cluster")
+	flag.Var(&globSelect, "glob", "glob expression matching image files to cluster (** matches across directories)")
 	flag.Var(&inJSON, "in-json", "path to read palette JSON from (go template)")
 	flag.Var(&outJSON, "out-json", "path to write palette JSON to (go template)")
 	flag.Var(&outPngSingle, "out-png", "path of output palette image (PNG) (go template)")
@@ -78,7 +116,62 @@ func init() {
 	flag.IntVar(&outColorSize, "out-cluster-png-height", 100, "size of each color square in the palette output image")
 	flag.Var(&outClusterJSON, "out-summary-json", "path of output JSON containing the clustering (go template)")
 	flag.Var(&outShell, "out-shell", "shell command to run for each image (go template)")
+	flag.Var(&outReport, "out-report", "directory to write an HTML cluster report with thumbnails to (go template)")
+	flag.IntVar(&outReportThumbSize, "out-report-thumb-size", 160, "maximum width and height of the thumbnails in the HTML report")
+	flag.Var(&include, "include", "when walking directories, only process files matching this glob (relative to the directory, ** matches across directories; repeatable)")
+	flag.Var(&exclude, "exclude", "when walking directories, skip files and directories matching this glob (repeatable)")
+	flag.IntVar(&walk.MaxDepth, "max-depth", 0, "when walking directories, maximum number of directory levels to visit (0 for no limit)")
+	flag.Var(&symlinks, "symlinks", fmt.Sprintf("when walking directories, which symlinks to follow (%s)", symlinks.Help()))
+	flag.StringVar(&cacheDir, "cache-dir", "", "directory of a persistent palette cache keyed by image content (disabled if empty)")
+	flag.BoolVar(&cacheClear, "cache-clear", false, "remove all entries from the palette cache before processing")
+	flag.Var(&algorithm, "algorithm", fmt.Sprintf("clustering algorithm for both palette extraction and image clustering (%s)", algorithm.Help()))
+	flag.Var(&colorSpace, "color-space", fmt.Sprintf("color space to cluster colors and palettes in (%s)", colorSpace.Help()))
+	flag.IntVar(&sample, "sample", 0, "use at most this many randomly chosen pixels per image (0 for all)")
+	flag.Int64Var(&seed, "seed", 0, "seed for random sampling and cluster initialization")
+	flag.IntVar(&workers, "workers", 0, "number of goroutines used to cluster the colors of a single image (0 for one per CPU)")
+	flag.IntVar(&streamAbove, "stream-above", 100, "decode PNG images with more than this many megapixels row by row into a color histogram instead of fully, bounding memory use (0 for all PNGs)")
+	flag.BoolVar(&noColorManagement, "no-color-management", false, "do not convert images with an embedded ICC profile to sRGB or apply their EXIF orientation")
+	flag.Var(&progressMode, "progress", fmt.Sprintf("how to report progress: a status line with ETA, periodic log lines, or not at all; auto uses a status line if stderr is a terminal (%s)", progressMode.Help()))
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors (implies -progress off)")
+	flag.Var(&logFormat, "log-format", fmt.Sprintf("format of the log written to stderr (%s)", logFormat.Help()))
+	flag.BoolVar(&failFast, "fail-fast", false, "stop at the first image that cannot be processed or output that cannot be written (same as -max-errors 1)")
+	flag.IntVar(&maxErrors, "max-errors", 0, "stop after this many images that cannot be processed or outputs that cannot be written (0 for no limit)")
+	flag.BoolVar(&overwrite, "overwrite", false, "replace output files that already exist (the default)")
+	flag.BoolVar(&skipExisting, "skip-existing", false, "do not write output files that already exist")
+	flag.BoolVar(&noClobber, "no-clobber", false, "do not write output files that already exist, and report an error for each")
+	flag.StringVar(&outErrors, "out-errors-json", "", "path of a JSON lines file with a record for each image that could not be processed and each output that could not be written (- for stdout)")
+}
+
+// parseFlags parses the command line and sets up logging, the walk options and the output policy
+func parseFlags() {
 	flag.Parse()
+	decodeOptions = input.DecodeOptions{IgnoreICC: noColorManagement, IgnoreOrientation: noColorManagement}
+	space, _ := palette.ParseColorSpace(colorSpace.Value)
+	extractOptions = palette.Options{K: kPalette, Algorithm: algorithm.Value, ColorSpace: space, Sample: sample, Seed: seed, Workers: workers}
+	clusterOptions = palette.Options{K: kImage, Algorithm: algorithm.Value, ColorSpace: space, Seed: seed, Workers: workers}
+	walk.Include = include.Values
+	walk.Exclude = exclude.Values
+	walk.Symlinks = symlinks.Value
+	walk.Archives = input.NewArchives()
+	level := slog.LevelInfo
+	if quiet {
+		progressMode.Value = progress.ModeOff
+		level = slog.LevelWarn
+	}
+	tracker = progress.New(os.Stderr, progressMode.Value)
+	slog.SetDefault(logging.New(tracker, logFormat.Value, level))
+	if failFast {
+		maxErrors = 1
+	}
+	switch {
+	case skipExisting:
+		outputs.Policy = output.SkipExisting
+	case noClobber:
+		outputs.Policy = output.NoClobber
+	}
+	if err := validateFlags(); err != nil {
+		status.UsageError(err)
+	}
 
 	if inJSON.Value == nil && inJSON.Text != "" {
 		inJSON.Set(defaultInJSON)
@@ -88,93 +181,167 @@ func init() {
 	}
 }
 
-func writeOutPngSingle(sourcePath string, label int, p []color.RGBA) {
-	b := bytes.NewBuffer(nil)
-	outPngSingle.Value.Execute(b, map[string]interface{}{
-		"Path":    sourcePath,
-		"N":       kImage,
-		"K":       kPalette,
-		"Label":   label,
-		"I":       label,
-		"Palette": p,
-	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
-	log.Println("writing", targetPath)
+func validateFlags() error {
+	if err := extractOptions.Validate(); err != nil {
+		return fmt.Errorf("-k: %v", err)
+	}
+	if err := clusterOptions.Validate(); err != nil {
+		return fmt.Errorf("-n: %v", err)
+	}
+	switch {
+	case maxParallel < 1:
+		return errors.New("-p must be positive")
+	case maxErrors < 0:
+		return errors.New("-max-errors must not be negative")
+	case overwrite && skipExisting, overwrite && noClobber, skipExisting && noClobber:
+		return errors.New("only one of -overwrite, -skip-existing and -no-clobber may be given")
+	case flag.NArg() == 0 && globSelect.Value == nil:
+		return errors.New("no images given (pass files or directories, or use -glob)")
+	}
+	return nil
+}
+
+// exitCode returns the exit code for the run so far
+func exitCode() int {
+	switch {
+	case ctx.Err() != nil || !clustered:
+		return status.Failure
+	case errs.Count() > 0:
+		return status.Partial
+	}
+	return status.OK
+}
+
+// fatal clears the status line and exits (see status.Fatal)
+func fatal(err error) {
+	tracker.Stop()
+	status.Fatal("", err)
+}
+
+// reportError logs that an image could not be processed or an output could not be written,
+// and records it if -out-errors-json is given. Errors without a stage are reported at `stage`.
+// Processing stops once -max-errors errors have been reported.
+func reportError(path, stage string, err error) {
+	if n := errs.Report(path, stage, err); maxErrors > 0 && n == int64(maxErrors) {
+		slog.Error("stopping", "errors", n)
+		cancel()
+	}
+	if outErrors == "" {
+		return
+	}
+	record := &schema.Error{Version: schema.Version, Tool: tool, Path: path, Stage: logging.StageOf(err, stage), Error: err.Error()}
+	if outErrors == "-" {
+		print <- record
+		return
+	}
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+	if err := errorsEnc.Encode(record); err != nil {
+		slog.Error("writing error record failed", "output", outErrors, "error", err)
+	}
+}
+
+func openErrors() {
+	if outErrors == "" || outErrors == "-" {
+		return
+	}
+	f, err := os.Create(outErrors)
 	if err != nil {
-		log.Println(err)
+		fatal(err)
+	}
+	errorsFile, errorsEnc = f, json.NewEncoder(f)
+}
+
+func closeErrors() {
+	if errorsFile == nil {
+		return
+	}
+	if err := errorsFile.Close(); err != nil {
+		slog.Error("writing error records failed", "output", outErrors, "error", err)
 	}
-	defer fOut.Close()
-	png.Encode(fOut, palette.Render(p, outColorSize))
 }
 
-func writeOutJSON(sourcePath string, pp pathPalette) {
+// executeTemplate renders an output path template
+func executeTemplate(t *flagvar.Template, data map[string]interface{}) (string, error) {
 	b := bytes.NewBuffer(nil)
-	outJSON.Value.Execute(b, map[string]interface{}{
-		"Path":    sourcePath,
-		"K":       kPalette,
-		"Palette": pp.Palette,
+	if err := t.Value.Execute(b, data); err != nil {
+		return "", logging.WithStage(logging.StageTemplate, err)
+	}
+	return b.String(), nil
+}
+
+func writeOutPngSingle(sourcePath string, label int, p []color.RGBA) error {
+	targetPath, err := executeTemplate(&outPngSingle, map[string]interface{}{
+		"Path":        sourcePath,
+		"ArchivePath": input.ArchivePath(sourcePath),
+		"EntryPath":   input.EntryPath(sourcePath),
+		"N":           kImage,
+		"K":           kPalette,
+		"Label":       label,
+		"I":           label,
+		"Palette":     p,
 	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
-	log.Println("writing", targetPath)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	defer fOut.Close()
-	var obj paletteJSON
-	obj.Path = pp.Path
-	obj.Palette = htmls(pp.Palette)
-	bytes, err := json.Marshal(obj)
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		return png.Encode(w, palette.Render(p, outColorSize))
+	})
+}
+
+func writeOutJSON(doc *schema.Palette) error {
+	targetPath, err := executeTemplate(&outJSON, map[string]interface{}{
+		"Path":        doc.Path,
+		"ArchivePath": input.ArchivePath(doc.Path),
+		"EntryPath":   input.EntryPath(doc.Path),
+		"K":           kPalette,
+		"Palette":     doc.Palette,
+	})
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	_, err = fOut.Write(bytes)
+	data, err := json.Marshal(doc)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
 
-func writeOutClusterJSON(obj interface{}) {
-	b := bytes.NewBuffer(nil)
-	outClusterJSON.Value.Execute(b, map[string]interface{}{
+func writeOutClusterJSON(obj interface{}) error {
+	targetPath, err := executeTemplate(&outClusterJSON, map[string]interface{}{
 		"N": kImage,
 		"K": kPalette,
 	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
-	log.Println("writing", targetPath)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	defer fOut.Close()
-	bytes, err := json.Marshal(obj)
+	data, err := json.Marshal(obj)
 	if err != nil {
-		log.Println(err)
-		return
-	}
-	_, err = fOut.Write(bytes)
-	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
 
-func runOutShell(path string, label int, p []color.RGBA) {
-	b := bytes.NewBuffer(nil)
-	outShell.Value.Execute(b, map[string]interface{}{
-		"Path":    path,
-		"N":       kImage,
-		"K":       kPalette,
-		"Label":   label,
-		"I":       label,
-		"Palette": p,
+func runOutShell(path string, label int, p []color.RGBA) error {
+	shellCmd, err := executeTemplate(&outShell, map[string]interface{}{
+		"Path":        path,
+		"ArchivePath": input.ArchivePath(path),
+		"EntryPath":   input.EntryPath(path),
+		"N":           kImage,
+		"K":           kPalette,
+		"Label":       label,
+		"I":           label,
+		"Palette":     p,
 	})
-	shellCmd := b.String()
+	if err != nil {
+		return err
+	}
 
 	var cmd *exec.Cmd
 	if shell, ok := os.LookupEnv("SHELL"); ok {
@@ -182,113 +349,204 @@ func runOutShell(path string, label int, p []color.RGBA) {
 	} else {
 		parts, err := shellquote.Split(shellCmd)
 		if err != nil {
-			log.Println(path, "error:", err)
-			return
+			return err
 		}
 		if len(parts) < 1 {
-			log.Println(path, "error:", "empty shell command")
-			return
+			return errors.New("empty shell command")
 		}
 		cmd = exec.Command(parts[0], parts[1:]...)
 	}
-	log.Println(path, "running", cmd.Args)
+	slog.Info("running", "path", path, "command", cmd.Args)
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		log.Println(path, "shell error:", err)
-	}
+	return cmd.Run()
 }
 
-func writeOutPng(label int, p []color.RGBA) {
-	b := bytes.NewBuffer(nil)
-	outPngCluster.Value.Execute(b, map[string]interface{}{
+func writeOutPng(label int, p []color.RGBA) error {
+	targetPath, err := executeTemplate(&outPngCluster, map[string]interface{}{
 		"N":       kImage,
 		"K":       kPalette,
 		"Label":   label,
 		"I":       label,
 		"Palette": p,
 	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	defer fOut.Close()
-	png.Encode(fOut, palette.Render(p, outColorSize))
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		return png.Encode(w, palette.Render(p, outColorSize))
+	})
 }
 
-func html(c color.RGBA) string {
-	if c.A == 255 {
-		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
-	}
-	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+// shouldStream returns whether an image is a PNG large enough to be decoded row by row
+func shouldStream(data []byte) bool {
+	config, typ, err := image.DecodeConfig(bytes.NewReader(data))
+	return err == nil && typ == "png" && config.Width*config.Height > streamAbove*1000000
 }
 
-func htmls(p []color.RGBA) (out []string) {
-	out = make([]string, len(p))
-	for i := range p {
-		out[i] = html(p[i])
+// streamPalette extracts the palette of a PNG image row by row, without decoding it fully
+func streamPalette(path string, data []byte) (*schema.Palette, error) {
+	counter, err := palette.NewColorCounter(extractOptions)
+	if err != nil {
+		return nil, err
+	}
+	config, err := input.StreamPNG(bytes.NewReader(data), decodeOptions, counter.Add)
+	if err == input.ErrStreamUnsupported {
+		return nil, err
+	}
+	if err != nil {
+		return nil, logging.WithStage(logging.StageDecode, err)
+	}
+	slog.Info("streamed", "path", path, "format", "png", "width", config.Width, "height", config.Height)
+	result, err := counter.Extract(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return
+	return schema.NewPalette(tool, path, image.Rect(0, 0, config.Width, config.Height), result), nil
 }
 
-func htmlss(ps [][]color.RGBA) (out [][]string) {
-	out = make([][]string, len(ps))
-	for i := range ps {
-		out[i] = htmls(ps[i])
+// extractPalette returns the palette document of an image, and whether it was found in the cache.
+// Errors are annotated with the stage they occurred at, if not extraction.
+func extractPalette(path string) (*schema.Palette, bool, error) {
+	data, err := walk.Archives.ReadFile(path)
+	if err != nil {
+		return nil, false, logging.WithStage(logging.StageRead, err)
+	}
+	streamed := shouldStream(data)
+	key := diskcache.Key(data, extractOptions.Key(), decodeOptions.Key(), "schema", strconv.Itoa(schema.Version), strconv.FormatBool(streamed))
+	var cached schema.Palette
+	if diskCache.GetValue(key, &cached) && cached.Version == schema.Version {
+		slog.Info("cached", "path", path, "key", key)
+		cached.Path, cached.Tool = path, tool
+		return &cached, true, nil
+	}
+	var doc *schema.Palette
+	if streamed {
+		doc, err = streamPalette(path, data)
+		if err != nil && err != input.ErrStreamUnsupported {
+			return nil, false, err
+		}
+	}
+	if doc == nil {
+		i, typ, err := input.DecodeWith(data, decodeOptions)
+		if err != nil {
+			return nil, false, logging.WithStage(logging.StageDecode, err)
+		}
+		slog.Info("loaded", "path", path, "format", typ, "width", i.Bounds().Dx(), "height", i.Bounds().Dy())
+		result, err := palette.ExtractWithOptions(ctx, i, extractOptions)
+		if err != nil {
+			return nil, false, err
+		}
+		doc = schema.NewPalette(tool, path, i.Bounds(), result)
 	}
-	return
+	cached = *doc
+	cached.Path, cached.Tool = "", nil
+	if err := diskCache.PutValue(key, &cached); err != nil {
+		slog.Warn("caching failed", "path", path, "error", err)
+	}
+	return doc, false, nil
 }
 
-func extractPalette(path string) ([]color.RGBA, error) {
-	f, err := os.Open(path)
+// loadPalette reads the palette document for an image from the path given by -in-json,
+// migrating documents written by older versions and rejecting invalid ones
+func loadPalette(path string) (*schema.Palette, error) {
+	targetPath, err := executeTemplate(&inJSON, map[string]interface{}{
+		"Path":        path,
+		"ArchivePath": input.ArchivePath(path),
+		"EntryPath":   input.EntryPath(path),
+		"N":           kImage,
+		"K":           kPalette,
+	})
 	if err != nil {
-		log.Println(path, "error:", err)
 		return nil, err
 	}
-	defer f.Close()
-	i, fmt, err := image.Decode(f)
+	f, err := os.Open(targetPath)
 	if err != nil {
-		log.Println(path, "error:", err)
 		return nil, err
 	}
-	log.Println(path, "loaded:", fmt, i.Bounds().Size().String())
-	return palette.Extract(colorCache, kPalette, i)
+	defer f.Close()
+	slog.Info("loading", "path", path, "input", targetPath)
+	doc, err := schema.ReadPalette(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", targetPath, err)
+	}
+	if doc.K != kPalette {
+		return nil, fmt.Errorf("%s: palette has %d colors, expected %d", targetPath, doc.K, kPalette)
+	}
+	doc.Path = path
+	return doc, nil
 }
 
-func loadPalette(path string) ([]color.RGBA, error) {
-	b := bytes.NewBuffer(nil)
-	inJSON.Value.Execute(b, map[string]interface{}{
-		"Path": path,
-		"N":    kImage,
-		"K":    kPalette,
-	})
-	targetPath := b.String()
-	f, err := os.Open(targetPath)
+func openCache() {
+	if cacheDir == "" {
+		return
+	}
+	c, err := diskcache.New(cacheDir)
 	if err != nil {
-		return nil, err
+		fatal(err)
+	}
+	if cacheClear {
+		slog.Info("clearing cache", "dir", cacheDir)
+		if err := c.Clear(); err != nil {
+			fatal(err)
+		}
+	}
+	diskCache = c
+}
+
+func logCacheStats() {
+	if diskCache == nil {
+		return
 	}
-	log.Println("loading", targetPath)
-	dec := json.NewDecoder(f)
-	var pj paletteJSON
-	err = dec.Decode(&pj)
+	s := diskCache.Stats()
+	slog.Info("cache", "hits", s.Hits, "misses", s.Misses, "errors", s.Errors)
+}
+
+// expandArgs calls `fn` with each image file given by `args` and -glob, as it is found.
+// Paths are passed on rather than collected, since tar entries are buffered until they are read.
+func expandArgs(args []string, fn func(path string)) {
+	for _, path := range args {
+		if err := input.Expand(path, walk, fn); err != nil {
+			reportError(path, logging.StageRead, err)
+		}
+	}
+	if globSelect.Value == nil {
+		return
+	}
+	matched := false
+	err := input.Walk(input.GlobBase(globSelect.Text), globWalkOptions(), func(path string) {
+		matched = true
+		fn(path)
+	})
 	if err != nil {
-		return nil, err
+		reportError("", logging.StageRead, err)
 	}
-	out := make([]color.RGBA, len(pj.Palette))
-	for i := range out {
-		var c color.RGBA
-		fmt.Sscanf(pj.Palette[i], "#%02x%02x%02x", &c.R, &c.G, &c.B)
-		c.A = 0xFF
-		out[i] = c
+	if !matched {
+		slog.Warn("no paths matched glob", "glob", globSelect.Text)
+	}
+}
+
+// globWalkOptions returns the options for walking the files that -glob may match.
+// Like a shell glob, it only matches across directories with `**`, so otherwise the walk
+// goes no deeper than the glob.
+func globWalkOptions() input.WalkOptions {
+	o := walk
+	o.Include = nil
+	o.Select = globSelect.Value.Match
+	if depth := input.GlobDepth(globSelect.Text); depth > 0 && (o.MaxDepth == 0 || depth < o.MaxDepth) {
+		o.MaxDepth = depth
 	}
-	return out, nil
+	return o
 }
 
 func main() {
-	print := make(chan interface{}, printBuffer)
+	parseFlags()
+	ctx, cancel = signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+	openCache()
+	openErrors()
+	print = make(chan interface{}, printBuffer)
 	var printWg sync.WaitGroup
 
 	printWg.Add(1)
@@ -296,11 +554,13 @@ func main() {
 		enc := json.NewEncoder(os.Stdout)
 		defer printWg.Done()
 		for obj := range print {
-			enc.Encode(obj)
+			if err := enc.Encode(obj); err != nil {
+				errs.Report("-", logging.StageWrite, err)
+			}
 		}
 	}()
 
-	cluster := make(chan pathPalette, clusterBuffer)
+	cluster := make(chan *schema.Palette, clusterBuffer)
 	var clusterWg sync.WaitGroup
 
 	clusterWg.Add(1)
@@ -308,40 +568,78 @@ func main() {
 		var paths []string
 		var palettes [][]color.RGBA
 		defer clusterWg.Done()
-		for pathPalette := range cluster {
-			paths = append(paths, pathPalette.Path)
-			sort.Slice(pathPalette.Palette, func(i int, j int) bool {
-				return colorSortOrder(pathPalette.Palette, i, j)
-			})
-			palettes = append(palettes, pathPalette.Palette)
+		for doc := range cluster {
+			paths = append(paths, doc.Path)
+			doc.Sort(colorSortOrder)
+			palettes = append(palettes, doc.Palette)
+		}
+		if ctx.Err() != nil {
+			slog.Error("stopped before all images were processed; not clustering")
+			return
+		}
+		if len(palettes) == 0 {
+			reportError("", logging.StageCluster, errors.New("no palettes to cluster"))
+			return
 		}
-		labels, centroids, err := palette.Cluster(paletteCache, kImage, palettes)
+		result, err := palette.ClusterWithOptions(ctx, palettes, clusterOptions)
 		if err != nil {
-			log.Fatal(err)
+			reportError("", logging.StageCluster, err)
+			return
+		}
+		sort.Strings(failed)
+		if len(failed) > 0 {
+			slog.Warn("clustering without failed images", "clustered", len(paths), "failed", len(failed))
 		}
+		labels, centroids := result.Labels, result.Centroids
 		if outPngCluster.Value != nil {
 			for i, p := range centroids {
-				writeOutPng(i, p)
+				if err := writeOutPng(i, p); err != nil {
+					reportError("", logging.StageWrite, err)
+				}
 			}
 		}
 		m := make(map[string]int, len(labels))
 		for i, l := range labels {
 			m[paths[i]] = l
-			writeOutPngSingle(paths[i], l, palettes[i])
+			if outPngSingle.Value != nil {
+				if err := writeOutPngSingle(paths[i], l, palettes[i]); err != nil {
+					reportError(paths[i], logging.StageWrite, err)
+				}
+			}
 		}
 		if outShell.Value != nil {
 			for i, l := range labels {
-				runOutShell(paths[i], l, palettes[i])
+				if ctx.Err() != nil {
+					break
+				}
+				if err := runOutShell(paths[i], l, palettes[i]); err != nil {
+					reportError(paths[i], logging.StageShell, err)
+				}
+			}
+		}
+		if outReport.Value != nil {
+			if err := writeOutReport(paths, palettes, labels, centroids); err != nil {
+				reportError("", logging.StageWrite, err)
 			}
 		}
-		obj := map[string]interface{}{
-			"centroids": htmlss(centroids),
-			"mapping":   m,
+		obj := &schema.Clustering{
+			Version:   schema.Version,
+			Tool:      tool,
+			N:         len(centroids),
+			Palette:   schema.NewOptions(extractOptions),
+			Cluster:   schema.NewOptions(clusterOptions),
+			Centroids: palette.Palettes(centroids),
+			Sizes:     result.Sizes,
+			Mapping:   m,
+			Failed:    failed,
 		}
 		if outClusterJSON.Value != nil {
-			writeOutClusterJSON(obj)
+			if err := writeOutClusterJSON(obj); err != nil {
+				reportError("", logging.StageWrite, err)
+			}
 		}
 		print <- obj
+		clustered = true
 	}()
 
 	var extractWg sync.WaitGroup
@@ -350,57 +648,74 @@ func main() {
 	for i := 0; i < maxParallel; i++ {
 		go func() {
 			defer extractWg.Done()
-			var p []color.RGBA
-			var err error
 			for path := range work {
+				if ctx.Err() != nil {
+					walk.Archives.Release(path)
+					continue
+				}
 				shouldWriteOutJSON := outJSON.Value != nil
+				var doc *schema.Palette
+				var err error
 				if inJSON.Value != nil {
-					p, err = loadPalette(path)
-					if len(p) < kPalette || err != nil {
-						p, err = extractPalette(path)
-						if err != nil {
-							log.Println(path, "error:", err)
-							continue
-						}
-					} else {
+					doc, err = loadPalette(path)
+					switch {
+					case os.IsNotExist(err):
+						// no palette JSON yet: extract the palette
+						slog.Debug("no palette to load", "path", path, "error", err)
+					case err != nil:
+						slog.Warn("loading palette failed", "path", path, "error", err)
+					default:
 						shouldWriteOutJSON = false
+						walk.Archives.Release(path)
+						tracker.Done(progress.Loaded, doc.Width*doc.Height)
+					}
+				}
+				if doc == nil {
+					var cached bool
+					doc, cached, err = extractPalette(path)
+					if err != nil && ctx.Err() != nil {
+						continue
 					}
-				} else {
-					p, err = extractPalette(path)
 					if err != nil {
-						log.Println(path, "error:", err)
+						reportError(path, logging.StageExtract, err)
+						tracker.Done(progress.Failed, 0)
+						failedMu.Lock()
+						failed = append(failed, path)
+						failedMu.Unlock()
 						continue
 					}
+					if cached {
+						tracker.Done(progress.Cached, doc.Width*doc.Height)
+					} else {
+						tracker.Done(progress.Extracted, doc.Width*doc.Height)
+					}
 				}
-				pp := pathPalette{Path: path, Palette: p}
 				if shouldWriteOutJSON {
-					writeOutJSON(path, pp)
+					if err := writeOutJSON(doc); err != nil {
+						reportError(path, logging.StageWrite, err)
+					}
 				}
-				cluster <- pp
+				cluster <- doc
 			}
 		}()
 	}
 
-	paths := flag.Args()
-	if globSelect.Value != nil {
-		globPaths, err := filepath.Glob(globSelect.Text)
-		if err != nil {
-			log.Println(err)
+	tracker.Start()
+	expandArgs(flag.Args(), func(path string) {
+		if ctx.Err() == nil {
+			tracker.Add(1)
+			work <- path
 		}
-		if len(globPaths) == 0 {
-			log.Println("no paths matched glob", globSelect.Text)
-		}
-		paths = append(paths, globPaths...)
-	}
-	log.Println("processing", len(paths), "files")
-	for i, path := range paths {
-		log.Printf("image %d/%d (%2.2f%%)", i, len(paths), float64(i)/float64(len(paths))*100)
-		work <- path
-	}
+	})
 	close(work)
 	extractWg.Wait()
+	tracker.Stop()
 	close(cluster)
 	clusterWg.Wait()
 	close(print)
 	printWg.Wait()
+	closeErrors()
+	logCacheStats()
+	slog.Info("done", "stats", tracker.Stats())
+	os.Exit(exitCode())
 }
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	flagvarGlob "github.com/sgreben/flagvar/glob"
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/schema"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// runMainEnv is set in the environment of the test binary when it is run as the command
+const runMainEnv = "CLUSTER_BY_PALETTE_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(status.OK)
+	}
+	os.Exit(m.Run())
+}
+
+// runCode runs the command with `args` in a subprocess and returns its exit code, stdout and stderr
+func runCode(t *testing.T, args ...string) (int, []byte, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode(), stdout.Bytes(), stderr.Bytes()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status.OK, stdout.Bytes(), stderr.Bytes()
+}
+
+// run runs the command with `args` in a subprocess and returns its stdout and stderr.
+// The command must succeed.
+func run(t *testing.T, args ...string) ([]byte, []byte) {
+	t.Helper()
+	code, stdout, stderr := runCode(t, args...)
+	if code != status.OK {
+		t.Fatalf("cluster-by-palette %q exited with %d\n%s", args, code, stderr)
+	}
+	return stdout, stderr
+}
+
+// checkGolden compares `got` with the golden file `name`, or updates it with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file (run go test -update to update it)\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// checkGoldenPNG compares the pixels of the PNG file `path` with those of the golden PNG file `name`,
+// so that changes of the PNG encoder do not matter
+func checkGoldenPNG(t *testing.T, name, path string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		checkGolden(t, name, data)
+		return
+	}
+	got, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join("testdata", "golden", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !samePixels(got, want) {
+		t.Errorf("%s differs from the golden file (run go test -update to update it)", name)
+	}
+}
+
+func samePixels(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.At(x, y) != b.At(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	images := []string{"cool1.png", "cool2.png", "green1.png", "green2.png", "warm1.png", "warm2.png"}
+	// farthest-point seeding reliably separates the three color themes, unlike k-means++ with few images
+	args := []string{
+		"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-n", "3", "-k", "4", "-algorithm", "kmeans-farthest",
+		"-out-json", filepath.Join(dir, "{{basename .Path}}.json"),
+		"-out-cluster-png", filepath.Join(dir, "cluster-{{.Label}}.png"),
+		"-out-summary-json", filepath.Join(dir, "summary.json"),
+	}
+	for _, name := range images {
+		args = append(args, filepath.Join("testdata", name))
+	}
+	stdout, _ := run(t, args...)
+	checkGolden(t, "stdout.jsonl", stdout)
+	summary, err := ioutil.ReadFile(filepath.Join(dir, "summary.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "summary.json", summary)
+	for _, name := range images {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, name+".json", data)
+	}
+	for _, name := range []string{"cluster-0.png", "cluster-1.png", "cluster-2.png"} {
+		checkGoldenPNG(t, name, filepath.Join(dir, name))
+	}
+
+	// images of the same colors belong to the same cluster, and each color to a different one
+	var clustering schema.Clustering
+	if err := json.Unmarshal(summary, &clustering); err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[int]bool)
+	for _, theme := range []string{"cool", "green", "warm"} {
+		a, b := clustering.Mapping[filepath.Join("testdata", theme+"1.png")], clustering.Mapping[filepath.Join("testdata", theme+"2.png")]
+		if a != b {
+			t.Errorf("%s images in clusters %d and %d", theme, a, b)
+		}
+		labels[a] = true
+	}
+	if len(labels) != 3 {
+		t.Errorf("clusters %v, want one per color theme", clustering.Mapping)
+	}
+}
+
+func TestInJSON(t *testing.T) {
+	dir := t.TempDir()
+	// cool1 has a palette JSON, cool2 a corrupt one, and the others none
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "golden", "cool1.png.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cool1.png.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cool2.png.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{
+		"-seed", "1", "-workers", "1", "-p", "1", "-n", "2", "-k", "4", "-log-format", "json",
+		"-in-json", filepath.Join(dir, "{{basename .Path}}.json"),
+	}
+	for _, name := range []string{"cool1.png", "cool2.png", "warm1.png", "warm2.png"} {
+		args = append(args, filepath.Join("testdata", name))
+	}
+	_, stderr := run(t, args...)
+	var loaded, warnings []string
+	for _, line := range bytes.Split(bytes.TrimSpace(stderr), []byte("\n")) {
+		var record struct {
+			Level string
+			Msg   string
+			Path  string
+		}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		switch {
+		case record.Msg == "loading":
+			loaded = append(loaded, record.Path)
+		case record.Level == "WARN":
+			warnings = append(warnings, record.Path)
+		}
+	}
+	if want := []string{filepath.Join("testdata", "cool1.png"), filepath.Join("testdata", "cool2.png")}; !reflect.DeepEqual(loaded, want) {
+		t.Errorf("loaded palettes of %v, want %v", loaded, want)
+	}
+	// missing palette JSON files are expected, and not warned about
+	if want := []string{filepath.Join("testdata", "cool2.png")}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings for %v, want %v\n%s", warnings, want, stderr)
+	}
+}
+
+// writeTar writes a tar archive of the files `names` in testdata to `path`
+func writeTar(t *testing.T, path string, names ...string) {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestGlobArchive checks that tar entries not matched by -glob are not buffered
+func TestGlobArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "images.tar")
+	writeTar(t, archive, "cool1.png", "cool2.png", "warm1.png")
+	defer func(w input.WalkOptions, g flagvarGlob.Glob) { walk, globSelect = w, g }(walk, globSelect)
+	for _, tt := range []struct {
+		glob string
+		want []string
+	}{
+		{filepath.Join(dir, "*.png"), nil},
+		{filepath.Join(dir, "*.tar!", "warm*.png"), []string{archive + input.ArchiveSeparator + "warm1.png"}},
+	} {
+		walk = input.WalkOptions{Archives: input.NewArchives()}
+		if err := globSelect.Set(tt.glob); err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		expandArgs(nil, func(path string) { paths = append(paths, path) })
+		if !reflect.DeepEqual(paths, tt.want) {
+			t.Errorf("-glob %s: paths %v, want %v", tt.glob, paths, tt.want)
+		}
+		for _, path := range paths {
+			if _, err := walk.Archives.ReadFile(path); err != nil {
+				t.Error(err)
+			}
+		}
+		if n := walk.Archives.Buffered(); n != 0 {
+			t.Errorf("-glob %s: %d archive entries still buffered", tt.glob, n)
+		}
+	}
+}
+
+// TestGlobDepth checks that -glob only walks as deep as the glob can match
+func TestGlobDepth(t *testing.T) {
+	dir := t.TempDir()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "cool1.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.png", filepath.Join("sub", "b.png"), filepath.Join("sub", "deep", "c.png")} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(w input.WalkOptions, g flagvarGlob.Glob) { walk, globSelect = w, g }(walk, globSelect)
+	tests := []struct {
+		glob     string
+		maxDepth int
+		depth    int
+		want     []string
+	}{
+		{"*.png", 0, 1, []string{"a.png"}},
+		{"*/*.png", 0, 2, []string{filepath.Join("sub", "b.png")}},
+		{"sub/*/*.png", 0, 2, []string{filepath.Join("sub", "deep", "c.png")}},
+		{"**/*.png", 0, 0, []string{filepath.Join("sub", "b.png"), filepath.Join("sub", "deep", "c.png")}},
+		// -max-depth still applies
+		{"**/*.png", 2, 2, []string{filepath.Join("sub", "b.png")}},
+		{"*/*/*.png", 2, 2, nil},
+	}
+	for _, tt := range tests {
+		walk = input.WalkOptions{Archives: input.NewArchives(), MaxDepth: tt.maxDepth}
+		if err := globSelect.Set(filepath.Join(dir, tt.glob)); err != nil {
+			t.Fatal(err)
+		}
+		if got := globWalkOptions().MaxDepth; got != tt.depth {
+			t.Errorf("-glob %s -max-depth %d: walking %d levels, want %d", tt.glob, tt.maxDepth, got, tt.depth)
+		}
+		var paths []string
+		expandArgs(nil, func(path string) {
+			rel, _ := filepath.Rel(dir, path)
+			paths = append(paths, rel)
+		})
+		if !reflect.DeepEqual(paths, tt.want) {
+			t.Errorf("-glob %s -max-depth %d: paths %v, want %v", tt.glob, tt.maxDepth, paths, tt.want)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	cool, warm := filepath.Join("testdata", "cool1.png"), filepath.Join("testdata", "warm1.png")
+	missing := filepath.Join(dir, "missing.png")
+	// corrupt images fail in the workers, which with -p 1 process the images in order
+	bad1, bad2 := filepath.Join(dir, "bad1.png"), filepath.Join(dir, "bad2.png")
+	for _, path := range []string{bad1, bad2} {
+		if err := ioutil.WriteFile(path, []byte("not an image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clustered := []string{"cluster-0.png", "cool1.png.json", "summary.json", "warm1.png.json"}
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		written []string
+		log     string
+	}{
+		{"ok", []string{cool, warm}, status.OK, clustered, ""},
+		{"some images fail", []string{cool, bad1, missing, warm}, status.Partial, clustered, ""},
+		{"all images fail", []string{bad1, missing, bad2}, status.Failure, nil, "no palettes to cluster"},
+		{"fail fast", []string{"-fail-fast", bad1, cool, warm}, status.Failure, nil, "not clustering"},
+		// the palettes extracted before stopping are written, but not clustered
+		{"max errors reached", []string{"-max-errors", "2", bad1, cool, bad2, warm}, status.Failure, []string{"cool1.png.json"}, "not clustering"},
+		{"max errors not reached", []string{"-max-errors", "2", bad1, cool, warm}, status.Partial, clustered, ""},
+		{"invalid flags", []string{"-max-errors", "-1", cool}, status.Usage, nil, ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, strconv.Itoa(i))
+			args := append([]string{
+				"-quiet", "-p", "1", "-workers", "1", "-n", "1",
+				"-out-json", filepath.Join(out, "{{basename .Path}}.json"),
+				"-out-cluster-png", filepath.Join(out, "cluster-{{.Label}}.png"),
+				"-out-summary-json", filepath.Join(out, "summary.json"),
+			}, tt.args...)
+			code, _, stderr := runCode(t, args...)
+			if code != tt.want {
+				t.Errorf("cluster-by-palette %q exited with %d, want %d\n%s", tt.args, code, tt.want, stderr)
+			}
+			if got := listFiles(t, out); !reflect.DeepEqual(got, tt.written) {
+				t.Errorf("cluster-by-palette %q wrote %v, want %v", tt.args, got, tt.written)
+			}
+			if !bytes.Contains(stderr, []byte(tt.log)) {
+				t.Errorf("cluster-by-palette %q did not log %q\n%s", tt.args, tt.log, stderr)
+			}
+		})
+	}
+}
+
+// listFiles returns the names of the files in `dir`, or nil if it does not exist
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
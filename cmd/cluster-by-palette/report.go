@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+	"github.com/sgreben/image-palette-tools/pkg/output"
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+)
+
+type reportImage struct {
+	Path     string
+	Link     string
+	Thumb    string
+	Distance float64
+	Palette  []string
+}
+
+type reportCluster struct {
+	Label    int
+	Centroid []string
+	Images   []reportImage
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>cluster-by-palette (n={{.N}}, k={{.K}})</title>
+<style>
+body { font-family: sans-serif; margin: 1em; background: #fafafa; }
+section { margin-bottom: 2em; }
+.palette { display: flex; }
+.palette div { flex: 1; height: 1.5em; }
+.centroid div { height: 3em; }
+.images { display: flex; flex-wrap: wrap; }
+figure { margin: 0.5em; width: {{.ThumbSize}}px; font-size: 0.7em; }
+figure img { display: block; max-width: 100%; }
+figcaption { overflow-wrap: anywhere; }
+</style>
+</head>
+<body>
+<h1>{{len .Clusters}} clusters, {{.Total}} images (n={{.N}}, k={{.K}})</h1>
+{{range .Clusters}}
+<section id="cluster-{{.Label}}">
+<h2>Cluster {{.Label}} ({{len .Images}} images)</h2>
+<div class="palette centroid">{{range .Centroid}}<div style="background: {{.}}" title="{{.}}"></div>{{end}}</div>
+<div class="images">
+{{range .Images}}<figure>
+<a href="{{.Link}}"><img src="{{.Thumb}}" alt="{{.Path}}"></a>
+<div class="palette">{{range .Palette}}<div style="background: {{.}}" title="{{.}}"></div>{{end}}</div>
+<figcaption>{{.Path}}<br>d={{printf "%.3f" .Distance}}</figcaption>
+</figure>
+{{end}}
+</div>
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+// thumbnail scales an image down to fit into a size x size box, averaging source pixels
+func thumbnail(i image.Image, size int) image.Image {
+	bounds := i.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return i
+	}
+	tw, th := size, size
+	if w > h {
+		th = size * h / w
+	} else {
+		tw = size * w / h
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	if tw >= w && th >= h {
+		return i
+	}
+	out := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := ty*h/th, (ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := tx*w/tw, (tx+1)*w/tw
+			var r, g, b, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, _ := i.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			out.SetRGBA(tx, ty, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: 255,
+			})
+		}
+	}
+	return out
+}
+
+// reportOutputs returns the writer for the files of the report. -skip-existing and -no-clobber
+// apply to the report as a whole (see writeOutReport), so its files are replaced.
+func reportOutputs() output.Writer {
+	w := outputs
+	w.Policy = output.Overwrite
+	return w
+}
+
+func writeThumbnail(sourcePath, targetPath string) error {
+	data, err := walk.Archives.ReadFile(sourcePath)
+	if err != nil {
+		return logging.WithStage(logging.StageRead, err)
+	}
+	i, _, err := input.DecodeWith(data, decodeOptions)
+	if err != nil {
+		return logging.WithStage(logging.StageDecode, err)
+	}
+	_, err = reportOutputs().WriteFile(targetPath, func(w io.Writer) error {
+		return jpeg.Encode(w, thumbnail(i, outReportThumbSize), &jpeg.Options{Quality: 85})
+	})
+	return logging.WithStage(logging.StageWrite, err)
+}
+
+func writeOutReport(paths []string, palettes [][]color.RGBA, labels []int, centroids [][]color.RGBA) error {
+	dir, err := executeTemplate(&outReport, map[string]interface{}{
+		"N": kImage,
+		"K": kPalette,
+	})
+	if err != nil {
+		return err
+	}
+	// -skip-existing and -no-clobber apply to the report as a whole
+	targetPath := filepath.Join(dir, "index.html")
+	if ok, err := outputs.Check(targetPath); !ok {
+		if err == nil {
+			slog.Info("skipped existing", "output", targetPath)
+		}
+		return logging.WithStage(logging.StageWrite, err)
+	}
+	thumbDir := filepath.Join(dir, "thumbs")
+
+	clusters := make([]reportCluster, len(centroids))
+	for i, p := range centroids {
+		clusters[i] = reportCluster{Label: i, Centroid: palette.Palette(p).Hex()}
+	}
+	for i, l := range labels {
+		// images inside archives link to their archive
+		path, entryPath := input.SplitPath(paths[i])
+		if path == "" {
+			path, entryPath = entryPath, ""
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		link := path
+		if entryPath != "" {
+			path += input.ArchiveSeparator + entryPath
+		}
+		clusters[l].Images = append(clusters[l].Images, reportImage{
+			Path:     path,
+			Link:     link,
+			Thumb:    fmt.Sprintf("thumbs/%d.jpg", i),
+			Distance: palette.SpaceDistance(palettes[i], centroids[l], clusterOptions.ColorSpace),
+			Palette:  palette.Palette(palettes[i]).Hex(),
+		})
+	}
+	for _, c := range clusters {
+		sort.SliceStable(c.Images, func(i, j int) bool { return c.Images[i].Distance < c.Images[j].Distance })
+	}
+
+	var wg sync.WaitGroup
+	work := make(chan int, maxParallel)
+	wg.Add(maxParallel)
+	for i := 0; i < maxParallel; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				targetPath := filepath.Join(thumbDir, fmt.Sprintf("%d.jpg", j))
+				if err := writeThumbnail(paths[j], targetPath); err != nil {
+					reportError(paths[j], logging.StageWrite, fmt.Errorf("thumbnail: %v", err))
+				}
+			}
+		}()
+	}
+	for j := range paths {
+		work <- j
+	}
+	close(work)
+	wg.Wait()
+
+	return reportOutputs().Write(targetPath, func(w io.Writer) error {
+		return reportTemplate.Execute(w, map[string]interface{}{
+			"N":         kImage,
+			"K":         kPalette,
+			"Total":     len(paths),
+			"ThumbSize": outReportThumbSize,
+			"Clusters":  clusters,
+		})
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	reportSection = regexp.MustCompile(`<section id="cluster-(\d+)">`)
+	reportFigure  = regexp.MustCompile(`<img src="([^"]+)" alt="([^"]+)">(?s:.*?)d=([0-9.]+)</figcaption>`)
+)
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	images := []string{"cool1.png", "cool2.png", "green1.png", "green2.png", "warm1.png", "warm2.png"}
+	args := []string{
+		"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-n", "3", "-k", "4", "-algorithm", "kmeans-farthest",
+		"-out-report", filepath.Join(dir, "report-{{.N}}"), "-out-report-thumb-size", "8",
+	}
+	for _, name := range images {
+		args = append(args, filepath.Join("testdata", name))
+	}
+	run(t, args...)
+	report := filepath.Join(dir, "report-3")
+	data, err := ioutil.ReadFile(filepath.Join(report, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+
+	sections := reportSection.FindAllStringSubmatchIndex(html, -1)
+	if len(sections) != 3 {
+		t.Fatalf("%d cluster sections, want 3", len(sections))
+	}
+	thumbs := make(map[string]bool)
+	for i, s := range sections {
+		label := html[s[2]:s[3]]
+		if label != strconv.Itoa(i) {
+			t.Errorf("section %d is cluster %s", i, label)
+		}
+		end := len(html)
+		if i+1 < len(sections) {
+			end = sections[i+1][0]
+		}
+		figures := reportFigure.FindAllStringSubmatch(html[s[1]:end], -1)
+		if len(figures) != 2 {
+			t.Errorf("cluster %s has %d images, want 2", label, len(figures))
+			continue
+		}
+		// images of the same color theme are clustered together, nearest to the centroid first
+		var themes []string
+		previous := -1.0
+		for _, f := range figures {
+			thumb, path := f[1], f[2]
+			themes = append(themes, strings.TrimRight(strings.TrimSuffix(filepath.Base(path), ".png"), "12"))
+			distance, err := strconv.ParseFloat(f[3], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if distance < previous {
+				t.Errorf("cluster %s: %s (d=%v) after an image at d=%v", label, path, distance, previous)
+			}
+			previous = distance
+			if !filepath.IsAbs(path) {
+				t.Errorf("cluster %s: image path %s is not absolute", label, path)
+			}
+			thumbs[thumb] = true
+		}
+		if themes[0] != themes[1] {
+			t.Errorf("cluster %s has images of the themes %v", label, themes)
+		}
+	}
+
+	// each image has a thumbnail that fits -out-report-thumb-size
+	if len(thumbs) != len(images) {
+		t.Errorf("%d distinct thumbnails, want %d", len(thumbs), len(images))
+	}
+	for thumb := range thumbs {
+		f, err := os.Open(filepath.Join(report, filepath.FromSlash(thumb)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		config, err := jpeg.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", thumb, err)
+			continue
+		}
+		if config.Width > 8 || config.Height > 8 {
+			t.Errorf("%s is %dx%d, want at most 8x8", thumb, config.Width, config.Height)
+		}
+	}
+}
+
+func TestReportPolicies(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report")
+	args := []string{"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-n", "1", "-k", "4", "-out-report", report,
+		filepath.Join("testdata", "cool1.png"), filepath.Join("testdata", "warm1.png")}
+	run(t, args...)
+	index := filepath.Join(report, "index.html")
+	if err := ioutil.WriteFile(index, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	thumb := filepath.Join(report, "thumbs", "0.jpg")
+	if err := os.Remove(thumb); err != nil {
+		t.Fatal(err)
+	}
+	// an existing report is left as it is
+	run(t, append([]string{"-skip-existing"}, args...)...)
+	if data, _ := ioutil.ReadFile(index); string(data) != "previous" {
+		t.Errorf("-skip-existing replaced the existing report")
+	}
+	if _, err := os.Stat(thumb); !os.IsNotExist(err) {
+		t.Errorf("-skip-existing wrote thumbnails of an existing report")
+	}
+	// otherwise, the whole report is written, replacing existing thumbnails
+	if err := os.Remove(index); err != nil {
+		t.Fatal(err)
+	}
+	run(t, append([]string{"-no-clobber"}, args...)...)
+	for _, path := range []string{index, thumb, filepath.Join(report, "thumbs", "1.jpg")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Error(err)
+		}
+	}
+}
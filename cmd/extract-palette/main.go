@@ -1,164 +1,616 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	_ "image/gif"
-	_ "image/jpeg"
 	"image/png"
 	"io"
-	"log"
+	"io/ioutil"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"runtime"
-	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"text/template"
 
+	flagvarEnum "github.com/sgreben/flagvar"
+	flagvarGlob "github.com/sgreben/flagvar/glob"
 	"github.com/sgreben/flagvar/template"
+	"github.com/sgreben/image-palette-tools/pkg/diskcache"
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+	"github.com/sgreben/image-palette-tools/pkg/output"
 	"github.com/sgreben/image-palette-tools/pkg/palette"
+	"github.com/sgreben/image-palette-tools/pkg/progress"
+	"github.com/sgreben/image-palette-tools/pkg/schema"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+	"github.com/sgreben/image-palette-tools/pkg/templates"
 )
 
 var (
-	k              int
-	outPng         = flagvar.Template{Root: templateSettings}
-	outTxt         = flagvar.Template{Root: templateSettings}
-	outJSON        = flagvar.Template{Root: templateSettings}
-	outColorSize   int
-	maxParallel    int
-	colorSortOrder = palette.LessLHS
-
-	templateSettings = template.New("").Funcs(map[string]interface{}{
-		"abs":      func(s string) (string, error) { return filepath.Abs(s) },
-		"basename": func(s string) string { return filepath.Base(s) },
-		"dirname":  func(s string) string { return filepath.Dir(s) },
-		"ext":      func(s string) string { return filepath.Ext(s) },
-		"html":     func(c color.RGBA) string { return html(c) },
-	})
-	cache       = palette.NewColorCache(512)
+	k               int
+	outPng          = flagvar.Template{Root: templateSettings}
+	outTxt          = flagvar.Template{Root: templateSettings}
+	outJSON         = flagvar.Template{Root: templateSettings}
+	outTimelinePng  = flagvar.Template{Root: templateSettings}
+	outTimelineJSON = flagvar.Template{Root: templateSettings}
+	frames          bool
+	rawPalette      bool
+	pathsFromStdin  bool
+	nulSeparated    bool
+	outFormat       = flagvarEnum.Enum{Choices: []string{"json", "hex", "gpl"}, Value: "json"}
+	frameStep       int
+	maxFrames       int
+	outColorSize    int
+	maxParallel     int
+	colorSortOrder  = palette.OrderLHS
+
+	templateSettings = templates.New()
+
+	ctx         = context.Background()
+	cancel      context.CancelFunc
+	cacheDir    string
+	cacheClear  bool
+	diskCache   *diskcache.Cache
+	include     = flagvarGlob.Globs{Separators: &[]rune{'/'}}
+	exclude     = flagvarGlob.Globs{Separators: &[]rune{'/'}}
+	symlinks    = flagvarEnum.Enum{Choices: input.SymlinkPolicies, Value: input.SymlinksFiles}
+	walk        input.WalkOptions
 	printBuffer = 1024
+	print       chan interface{}
+
+	quiet        bool
+	progressMode = flagvarEnum.Enum{Choices: progress.Modes, Value: progress.ModeAuto}
+	tracker      *progress.Tracker
+	logFormat    = flagvarEnum.Enum{Choices: logging.Formats, Value: logging.FormatText}
+	outErrors    string
+	errorsFile   *os.File
+	errorsEnc    *json.Encoder
+	errorsMu     sync.Mutex
+	errs         status.Errors
+	failFast     bool
+	maxErrors    int
+	overwrite    bool
+	skipExisting bool
+	noClobber    bool
+	outputs      output.Writer
+
+	noColorManagement bool
+	decodeOptions     input.DecodeOptions
+	streamAbove       int
+
+	algorithm  = flagvarEnum.Enum{Choices: palette.Algorithms, Value: palette.AlgorithmKmeansPP}
+	colorSpace = flagvarEnum.Enum{Choices: palette.ColorSpaces, Value: "hsl"}
+	sample     int
+	seed       int64
+	workers    int
+	options    palette.Options
+
+	version = "dev"
+	tool    = &schema.Tool{Name: "extract-palette", Version: version}
 )
 
 func init() {
-	log.SetOutput(os.Stderr)
 	flag.IntVar(&k, "k", 8, "number of colors to extract")
 	flag.IntVar(&maxParallel, "p", runtime.GOMAXPROCS(0), "number of images to process in parallel")
 	flag.Var(&outPng, "out-png", "path of output palette image (PNG) (go template)")
 	flag.IntVar(&outColorSize, "out-png-height", 100, "size of each color square in the palette output image")
 	flag.Var(&outTxt, "out-txt", "path of output text file (go template)")
 	flag.Var(&outJSON, "out-json", "path of output JSON file (go template)")
+	flag.BoolVar(&pathsFromStdin, "stdin", false, "read newline-separated paths of images, directories or archives from stdin")
+	flag.BoolVar(&nulSeparated, "0", false, "read NUL-separated paths of images, directories or archives from stdin (as written by find -print0)")
+	flag.Var(&outFormat, "format", fmt.Sprintf("format of the palettes written to stdout: JSON lines, tab-separated path and hex colors, or GIMP palettes (%s)", outFormat.Help()))
+	flag.BoolVar(&rawPalette, "raw-palette", false, "output the color table embedded in paletted images (GIF, 8-bit PNG) as-is instead of extracting a palette")
+	flag.BoolVar(&frames, "frames", false, "extract palettes from all frames of animated images (GIF)")
+	flag.IntVar(&frameStep, "frame-step", 1, "with -frames, use only every n-th frame")
+	flag.IntVar(&maxFrames, "max-frames", 0, "with -frames, use at most this many evenly spaced frames (0 for all)")
+	flag.Var(&outTimelinePng, "out-timeline-png", "with -frames, path of output palette timeline image (PNG) with one row per frame (go template)")
+	flag.Var(&outTimelineJSON, "out-timeline-json", "with -frames, path of output JSON array of per-frame palettes (go template)")
+	flag.Var(&include, "include", "when walking directories, only process files matching this glob (relative to the directory, ** matches across directories; repeatable)")
+	flag.Var(&exclude, "exclude", "when walking directories, skip files and directories matching this glob (repeatable)")
+	flag.IntVar(&walk.MaxDepth, "max-depth", 0, "when walking directories, maximum number of directory levels to visit (0 for no limit)")
+	flag.Var(&symlinks, "symlinks", fmt.Sprintf("when walking directories, which symlinks to follow (%s)", symlinks.Help()))
+	flag.StringVar(&cacheDir, "cache-dir", "", "directory of a persistent palette cache keyed by image content (disabled if empty)")
+	flag.BoolVar(&cacheClear, "cache-clear", false, "remove all entries from the palette cache before processing")
+	flag.Var(&algorithm, "algorithm", fmt.Sprintf("clustering algorithm (%s)", algorithm.Help()))
+	flag.Var(&colorSpace, "color-space", fmt.Sprintf("color space to cluster colors in (%s)", colorSpace.Help()))
+	flag.IntVar(&sample, "sample", 0, "use at most this many randomly chosen pixels per image (0 for all)")
+	flag.Int64Var(&seed, "seed", 0, "seed for random sampling and cluster initialization")
+	flag.IntVar(&workers, "workers", 0, "number of goroutines used to cluster the colors of a single image (0 for one per CPU)")
+	flag.IntVar(&streamAbove, "stream-above", 100, "decode PNG images with more than this many megapixels row by row into a color histogram instead of fully, bounding memory use (0 for all PNGs)")
+	flag.BoolVar(&noColorManagement, "no-color-management", false, "do not convert images with an embedded ICC profile to sRGB or apply their EXIF orientation")
+	flag.Var(&progressMode, "progress", fmt.Sprintf("how to report progress: a status line with ETA, periodic log lines, or not at all; auto uses a status line if stderr is a terminal (%s)", progressMode.Help()))
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors (implies -progress off)")
+	flag.Var(&logFormat, "log-format", fmt.Sprintf("format of the log written to stderr (%s)", logFormat.Help()))
+	flag.BoolVar(&failFast, "fail-fast", false, "stop at the first image that cannot be processed or output that cannot be written (same as -max-errors 1)")
+	flag.IntVar(&maxErrors, "max-errors", 0, "stop after this many images that cannot be processed or outputs that cannot be written (0 for no limit)")
+	flag.BoolVar(&overwrite, "overwrite", false, "replace output files that already exist (the default)")
+	flag.BoolVar(&skipExisting, "skip-existing", false, "do not write output files that already exist")
+	flag.BoolVar(&noClobber, "no-clobber", false, "do not write output files that already exist, and report an error for each")
+	flag.StringVar(&outErrors, "out-errors-json", "", "path of a JSON lines file with a record for each image that could not be processed and each output that could not be written (- for stdout)")
+}
+
+// parseFlags parses the command line and sets up what depends on the flags. It is called by main
+// rather than by init, so that the flags of the command's test binary are registered first.
+func parseFlags() {
 	flag.Parse()
+	decodeOptions = input.DecodeOptions{IgnoreICC: noColorManagement, IgnoreOrientation: noColorManagement}
+	space, _ := palette.ParseColorSpace(colorSpace.Value)
+	options = palette.Options{K: k, Algorithm: algorithm.Value, ColorSpace: space, Sample: sample, Seed: seed, Workers: workers}
+	walk.Include = include.Values
+	walk.Exclude = exclude.Values
+	walk.Symlinks = symlinks.Value
+	walk.Archives = input.NewArchives()
+	level := slog.LevelInfo
+	if quiet {
+		progressMode.Value = progress.ModeOff
+		level = slog.LevelWarn
+	}
+	tracker = progress.New(os.Stderr, progressMode.Value)
+	slog.SetDefault(logging.New(tracker, logFormat.Value, level))
+	if failFast {
+		maxErrors = 1
+	}
+	switch {
+	case skipExisting:
+		outputs.Policy = output.SkipExisting
+	case noClobber:
+		outputs.Policy = output.NoClobber
+	}
+	if err := validateFlags(); err != nil {
+		status.UsageError(err)
+	}
 }
 
-func writeOutPng(sourcePath string, p []color.RGBA) {
-	b := bytes.NewBuffer(nil)
-	outPng.Value.Execute(b, map[string]interface{}{
-		"Path":    sourcePath,
-		"K":       k,
-		"Palette": p,
-	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
-	log.Println("writing", targetPath)
-	if err != nil {
-		log.Println(err)
+func validateFlags() error {
+	if err := options.Validate(); err != nil {
+		return fmt.Errorf("-k: %v", err)
+	}
+	switch {
+	case maxParallel < 1:
+		return errors.New("-p must be positive")
+	case maxErrors < 0:
+		return errors.New("-max-errors must not be negative")
+	case overwrite && skipExisting, overwrite && noClobber, skipExisting && noClobber:
+		return errors.New("only one of -overwrite, -skip-existing and -no-clobber may be given")
+	case frameStep < 1:
+		return errors.New("-frame-step must be positive")
+	}
+	fromStdin := nulSeparated || pathsFromStdin
+	for _, path := range flag.Args() {
+		if fromStdin && path == "-" {
+			return errors.New("cannot read both an image and paths from stdin")
+		}
+	}
+	if flag.NArg() == 0 && !fromStdin {
+		return errors.New("no images given (use - to read an image from stdin, or -stdin or -0 to read paths from stdin)")
 	}
-	defer fOut.Close()
-	png.Encode(fOut, palette.Render(p, outColorSize))
+	return nil
 }
 
-func html(c color.RGBA) string {
-	if c.A == 255 {
-		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+// exitCode returns the exit code for the images processed so far
+func exitCode() int {
+	s := tracker.Stats()
+	n := errs.Count()
+	switch {
+	case ctx.Err() != nil:
+		return status.Failure
+	case n > 0 && s.Done() == s.Failed:
+		return status.Failure
+	case n > 0:
+		return status.Partial
 	}
-	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+	return status.OK
 }
 
-func htmls(p []color.RGBA) (out []string) {
-	out = make([]string, len(p))
-	for i := range p {
-		out[i] = html(p[i])
+// fatal clears the status line and exits (see status.Fatal)
+func fatal(err error) {
+	tracker.Stop()
+	status.Fatal("", err)
+}
+
+// reportError logs that an image could not be processed or an output could not be written,
+// and records it if -out-errors-json is given. Errors without a stage are reported at `stage`.
+// Processing stops once -max-errors errors have been reported.
+func reportError(path, stage string, err error) {
+	if n := errs.Report(path, stage, err); maxErrors > 0 && n == int64(maxErrors) {
+		slog.Error("stopping", "errors", n)
+		cancel()
+	}
+	if outErrors == "" {
+		return
+	}
+	record := &schema.Error{Version: schema.Version, Tool: tool, Path: path, Stage: logging.StageOf(err, stage), Error: err.Error()}
+	if outErrors == "-" {
+		print <- record
+		return
+	}
+	errorsMu.Lock()
+	defer errorsMu.Unlock()
+	if err := errorsEnc.Encode(record); err != nil {
+		slog.Error("writing error record failed", "output", outErrors, "error", err)
 	}
-	return
 }
 
-func writeOutTxt(sourcePath string, p []color.RGBA) {
+func openErrors() {
+	if outErrors == "" || outErrors == "-" {
+		return
+	}
+	f, err := os.Create(outErrors)
+	if err != nil {
+		fatal(err)
+	}
+	errorsFile, errorsEnc = f, json.NewEncoder(f)
+}
+
+func closeErrors() {
+	if errorsFile == nil {
+		return
+	}
+	if err := errorsFile.Close(); err != nil {
+		slog.Error("writing error records failed", "output", outErrors, "error", err)
+	}
+}
+
+// executeTemplate renders an output path template
+func executeTemplate(t *flagvar.Template, data map[string]interface{}) (string, error) {
 	b := bytes.NewBuffer(nil)
-	outTxt.Value.Execute(b, map[string]interface{}{
-		"Path":    sourcePath,
-		"K":       k,
-		"Palette": p,
+	if err := t.Value.Execute(b, data); err != nil {
+		return "", logging.WithStage(logging.StageTemplate, err)
+	}
+	return b.String(), nil
+}
+
+func writeOutPng(sourcePath string, p []color.RGBA) error {
+	targetPath, err := executeTemplate(&outPng, map[string]interface{}{
+		"Path":        sourcePath,
+		"ArchivePath": input.ArchivePath(sourcePath),
+		"EntryPath":   input.EntryPath(sourcePath),
+		"K":           k,
+		"Palette":     p,
 	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
-	log.Println("writing", targetPath)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	defer fOut.Close()
-	for _, c := range p {
-		io.WriteString(fOut, html(c))
-		io.WriteString(fOut, "\n")
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		return png.Encode(w, palette.Render(p, outColorSize))
+	})
+}
+
+func writeOutTxt(sourcePath string, p []color.RGBA) error {
+	targetPath, err := executeTemplate(&outTxt, map[string]interface{}{
+		"Path":        sourcePath,
+		"ArchivePath": input.ArchivePath(sourcePath),
+		"EntryPath":   input.EntryPath(sourcePath),
+		"K":           k,
+		"Palette":     p,
+	})
+	if err != nil {
+		return err
 	}
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		for _, c := range p {
+			if _, err := io.WriteString(w, palette.Hex(c)+"\n"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func writeOutJSON(sourcePath string, p []color.RGBA, obj interface{}) {
-	b := bytes.NewBuffer(nil)
-	outJSON.Value.Execute(b, map[string]interface{}{
-		"Path":    sourcePath,
-		"K":       k,
-		"Palette": p,
+func writeOutJSON(doc *schema.Palette) error {
+	targetPath, err := executeTemplate(&outJSON, map[string]interface{}{
+		"Path":        doc.Path,
+		"ArchivePath": input.ArchivePath(doc.Path),
+		"EntryPath":   input.EntryPath(doc.Path),
+		"K":           k,
+		"Palette":     doc.Palette,
 	})
-	targetPath := b.String()
-	fOut, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, 0600)
-	log.Println("writing", targetPath)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	defer fOut.Close()
-	bytes, err := json.Marshal(obj)
+	data, err := json.Marshal(doc)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
+	}
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// readInput reads an image file or archive entry, or stdin if the path is "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
 	}
-	_, err = fOut.Write(bytes)
+	return walk.Archives.ReadFile(path)
+}
+
+// expand sends the image at `path`, or the images below it if it is a directory or an archive, to `work`
+func expand(work chan<- string, path string) {
+	err := input.Expand(path, walk, func(path string) {
+		if ctx.Err() == nil {
+			tracker.Add(1)
+			work <- path
+		}
+	})
 	if err != nil {
-		log.Println(err)
-		return
+		reportError(path, logging.StageRead, err)
+	}
+}
+
+// readPaths expands the paths read from stdin, separated by `sep`, like those given as arguments
+func readPaths(work chan<- string, sep byte) {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		path, err := r.ReadString(sep)
+		path = strings.TrimSuffix(path, string(sep))
+		if sep == '\n' {
+			path = strings.TrimSuffix(path, "\r")
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if path != "" {
+			expand(work, path)
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			reportError("", logging.StageRead, fmt.Errorf("reading paths from stdin: %v", err))
+			return
+		}
 	}
 }
 
-func extractPalette(path string) ([]color.RGBA, error) {
-	f, err := os.Open(path)
+func printPalette(w io.Writer, enc *json.Encoder, doc *schema.Palette) error {
+	switch outFormat.Value {
+	case "hex":
+		_, err := fmt.Fprintf(w, "%s\t%s\n", doc.Path, strings.Join(doc.Palette.Hex(), " "))
+		return err
+	case "gpl":
+		return palette.WriteGPL(w, doc.Path, doc.Palette)
+	}
+	return enc.Encode(doc)
+}
+
+// cachedDocument returns the document cached under `key`
+func cachedDocument(path, key string) (*schema.Palette, bool) {
+	var doc schema.Palette
+	if !diskCache.GetValue(key, &doc) || doc.Version != schema.Version {
+		return nil, false
+	}
+	doc.Path, doc.Tool = path, tool
+	return &doc, true
+}
+
+// cacheDocument stores a document, without its path, under `key`
+func cacheDocument(key string, doc *schema.Palette) {
+	cached := *doc
+	cached.Path, cached.Tool = "", nil
+	if err := diskCache.PutValue(key, &cached); err != nil {
+		slog.Warn("caching failed", "path", doc.Path, "error", err)
+	}
+}
+
+// shouldStream returns whether an image is a PNG large enough to be decoded row by row
+func shouldStream(data []byte) bool {
+	config, typ, err := image.DecodeConfig(bytes.NewReader(data))
+	return err == nil && typ == "png" && config.Width*config.Height > streamAbove*1000000
+}
+
+// streamPalette extracts the palette of a PNG image row by row, without decoding it fully
+func streamPalette(path string, data []byte) (*schema.Palette, error) {
+	counter, err := palette.NewColorCounter(options)
 	if err != nil {
-		log.Println(path, "error:", err)
 		return nil, err
 	}
-	defer f.Close()
-	i, typ, err := image.Decode(f)
+	config, err := input.StreamPNG(bytes.NewReader(data), decodeOptions, counter.Add)
+	if err == input.ErrStreamUnsupported {
+		return nil, err
+	}
+	if err != nil {
+		return nil, logging.WithStage(logging.StageDecode, err)
+	}
+	slog.Info("streamed", "path", path, "format", "png", "width", config.Width, "height", config.Height)
+	result, err := counter.Extract(ctx)
 	if err != nil {
-		log.Println(path, "error:", err)
 		return nil, err
 	}
-	log.Println(path, "loaded:", typ, i.Bounds().Size().String())
-	return palette.Extract(cache, k, i)
+	return schema.NewPalette(tool, path, image.Rect(0, 0, config.Width, config.Height), result), nil
+}
+
+// extractPalette returns the palette document of an image, and whether it was found in the cache.
+// Errors are annotated with the stage they occurred at, if not extraction.
+func extractPalette(path string) (*schema.Palette, bool, error) {
+	data, err := readInput(path)
+	if err != nil {
+		return nil, false, logging.WithStage(logging.StageRead, err)
+	}
+	if rawPalette {
+		i, _, err := input.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, false, logging.WithStage(logging.StageDecode, err)
+		}
+		p, ok := palette.Embedded(i)
+		if !ok {
+			return nil, false, errors.New("image has no embedded palette")
+		}
+		return &schema.Palette{
+			Version: schema.Version,
+			Tool:    tool,
+			Options: schema.Options{K: len(p), Algorithm: "embedded"},
+			Path:    path,
+			Width:   i.Bounds().Dx(),
+			Height:  i.Bounds().Dy(),
+			Palette: p,
+		}, false, nil
+	}
+	if frames {
+		return extractFramePalettes(path, data)
+	}
+	streamed := shouldStream(data)
+	key := diskcache.Key(data, options.Key(), decodeOptions.Key(), "schema", strconv.Itoa(schema.Version), strconv.FormatBool(streamed))
+	if doc, ok := cachedDocument(path, key); ok {
+		slog.Info("cached", "path", path, "key", key)
+		return doc, true, nil
+	}
+	if streamed {
+		doc, err := streamPalette(path, data)
+		if err == nil {
+			cacheDocument(key, doc)
+			return doc, false, nil
+		}
+		if err != input.ErrStreamUnsupported {
+			return nil, false, err
+		}
+	}
+	i, typ, err := input.DecodeWith(data, decodeOptions)
+	if err != nil {
+		return nil, false, logging.WithStage(logging.StageDecode, err)
+	}
+	slog.Info("loaded", "path", path, "format", typ, "width", i.Bounds().Dx(), "height", i.Bounds().Dy())
+	result, err := palette.ExtractWithOptions(ctx, i, options)
+	if err != nil {
+		return nil, false, err
+	}
+	doc := schema.NewPalette(tool, path, i.Bounds(), result)
+	cacheDocument(key, doc)
+	return doc, false, nil
+}
+
+func extractFramePalettes(path string, data []byte) (*schema.Palette, bool, error) {
+	key := diskcache.Key(data, options.Key(), decodeOptions.Key(), "frames", strconv.Itoa(frameStep), strconv.Itoa(maxFrames), "schema", strconv.Itoa(schema.Version))
+	if doc, ok := cachedDocument(path, key); ok {
+		slog.Info("cached", "path", path, "key", key)
+		return doc, true, nil
+	}
+	all, typ, err := input.DecodeFramesWith(data, decodeOptions)
+	if err != nil {
+		return nil, false, logging.WithStage(logging.StageDecode, err)
+	}
+	is := input.SampleFrames(all, frameStep, maxFrames)
+	slog.Info("loaded", "path", path, "format", typ, "width", is[0].Bounds().Dx(), "height", is[0].Bounds().Dy(), "frames", len(is), "totalFrames", len(all))
+	result, err := palette.ExtractFramesWithOptions(ctx, is, options)
+	if err != nil {
+		return nil, false, err
+	}
+	doc := schema.NewPalette(tool, path, is[0].Bounds(), result)
+	for _, i := range is {
+		frame, err := palette.ExtractWithOptions(ctx, i, options)
+		if err != nil {
+			return nil, false, err
+		}
+		doc.Frames = append(doc.Frames, frame.Palette)
+	}
+	cacheDocument(key, doc)
+	return doc, false, nil
+}
+
+func writeOutTimelinePng(sourcePath string, frames []palette.Palette) error {
+	targetPath, err := executeTemplate(&outTimelinePng, map[string]interface{}{
+		"Path":        sourcePath,
+		"ArchivePath": input.ArchivePath(sourcePath),
+		"EntryPath":   input.EntryPath(sourcePath),
+		"K":           k,
+		"Frames":      frames,
+	})
+	if err != nil {
+		return err
+	}
+	fps := make([][]color.RGBA, len(frames))
+	for i, f := range frames {
+		fps[i] = f
+	}
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		return png.Encode(w, palette.RenderTimeline(fps, outColorSize))
+	})
+}
+
+func writeOutTimelineJSON(sourcePath string, frames []palette.Palette) error {
+	targetPath, err := executeTemplate(&outTimelineJSON, map[string]interface{}{
+		"Path":        sourcePath,
+		"ArchivePath": input.ArchivePath(sourcePath),
+		"EntryPath":   input.EntryPath(sourcePath),
+		"K":           k,
+		"Frames":      frames,
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(frames)
+	if err != nil {
+		return err
+	}
+	return outputs.Write(targetPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func openCache() {
+	if cacheDir == "" {
+		return
+	}
+	c, err := diskcache.New(cacheDir)
+	if err != nil {
+		fatal(err)
+	}
+	if cacheClear {
+		slog.Info("clearing cache", "dir", cacheDir)
+		if err := c.Clear(); err != nil {
+			fatal(err)
+		}
+	}
+	diskCache = c
+}
+
+func logCacheStats() {
+	if diskCache == nil {
+		return
+	}
+	s := diskCache.Stats()
+	slog.Info("cache", "hits", s.Hits, "misses", s.Misses, "errors", s.Errors)
 }
 
 func main() {
-	print := make(chan interface{}, printBuffer)
+	parseFlags()
+	ctx, cancel = signal.NotifyContext(ctx, os.Interrupt)
+	defer cancel()
+	tracker.Start()
+	openCache()
+	openErrors()
+	print = make(chan interface{}, printBuffer)
 	var printWg sync.WaitGroup
 
 	printWg.Add(1)
 	go func() {
-		enc := json.NewEncoder(os.Stdout)
+		out := bufio.NewWriter(os.Stdout)
+		enc := json.NewEncoder(out)
 		defer printWg.Done()
 		for obj := range print {
-			enc.Encode(obj)
+			var err error
+			if doc, ok := obj.(*schema.Palette); ok {
+				err = printPalette(out, enc, doc)
+			} else {
+				err = enc.Encode(obj)
+			}
+			if err != nil {
+				errs.Report("-", logging.StageWrite, err)
+			}
+		}
+		if err := out.Flush(); err != nil {
+			errs.Report("-", logging.StageWrite, err)
 		}
 	}()
 
@@ -169,37 +621,72 @@ func main() {
 		go func() {
 			defer workWg.Done()
 			for path := range work {
-				p, err := extractPalette(path)
-				sort.Slice(p, func(i int, j int) bool {
-					return colorSortOrder(p, i, j)
-				})
+				if ctx.Err() != nil {
+					continue
+				}
+				doc, cached, err := extractPalette(path)
+				if err != nil && ctx.Err() != nil {
+					continue
+				}
 				if err != nil {
-					log.Println(path, "error:", err)
+					reportError(path, logging.StageExtract, err)
+					tracker.Done(progress.Failed, 0)
 					continue
 				}
+				if cached {
+					tracker.Done(progress.Cached, doc.Width*doc.Height)
+				} else {
+					tracker.Done(progress.Extracted, doc.Width*doc.Height)
+				}
+				if !rawPalette {
+					doc.Sort(colorSortOrder)
+				}
+				report := func(err error) {
+					if err != nil {
+						reportError(path, logging.StageWrite, err)
+					}
+				}
 				if outPng.Value != nil {
-					writeOutPng(path, p)
+					report(writeOutPng(path, doc.Palette))
 				}
 				if outTxt.Value != nil {
-					writeOutTxt(path, p)
+					report(writeOutTxt(path, doc.Palette))
 				}
-				jsonObj := map[string]interface{}{
-					"path":    path,
-					"palette": htmls(p),
+				if doc.Frames != nil {
+					if outTimelinePng.Value != nil {
+						report(writeOutTimelinePng(path, doc.Frames))
+					}
+					if outTimelineJSON.Value != nil {
+						report(writeOutTimelineJSON(path, doc.Frames))
+					}
 				}
 				if outJSON.Value != nil {
-					writeOutJSON(path, p, jsonObj)
+					report(writeOutJSON(doc))
 				}
-				log.Println(path, htmls(p))
-				print <- jsonObj
+				slog.Info("palette", "path", path, "palette", doc.Palette.Hex())
+				print <- doc
 			}
 		}()
 	}
 	for _, path := range flag.Args() {
-		work <- path
+		expand(work, path)
+	}
+	switch {
+	case nulSeparated:
+		readPaths(work, 0)
+	case pathsFromStdin:
+		readPaths(work, '\n')
 	}
 	close(work)
 	workWg.Wait()
 	close(print)
 	printWg.Wait()
+	tracker.Stop()
+	closeErrors()
+	logCacheStats()
+	slog.Info("done", "stats", tracker.Stats())
+	if ctx.Err() != nil {
+		slog.Error("stopped before all images were processed")
+	}
+	os.Exit(exitCode())
 }
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/schema"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// runMainEnv is set in the environment of the test binary when it is run as the command
+const runMainEnv = "EXTRACT_PALETTE_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(status.OK)
+	}
+	os.Exit(m.Run())
+}
+
+// runCode runs the command with `args` in a subprocess and returns its exit code, stdout and stderr
+func runCode(t *testing.T, args ...string) (int, []byte, []byte) {
+	t.Helper()
+	return runStdin(t, nil, args...)
+}
+
+// runStdin runs the command like runCode, with `stdin` as its standard input
+func runStdin(t *testing.T, stdin []byte, args ...string) (int, []byte, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode(), stdout.Bytes(), stderr.Bytes()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status.OK, stdout.Bytes(), stderr.Bytes()
+}
+
+// run runs the command with `args` in a subprocess and returns its stdout. The command must succeed.
+func run(t *testing.T, args ...string) []byte {
+	t.Helper()
+	code, stdout, stderr := runCode(t, args...)
+	if code != status.OK {
+		t.Fatalf("extract-palette %q exited with %d\n%s", args, code, stderr)
+	}
+	return stdout
+}
+
+// listFiles returns the names of the files in `dir`, or nil if it does not exist
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// checkGolden compares `got` with the golden file `name`, or updates it with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file (run go test -update to update it)\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// checkGoldenPNG compares the pixels of the PNG file `path` with those of the golden PNG file `name`,
+// so that changes of the PNG encoder do not matter
+func checkGoldenPNG(t *testing.T, name, path string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		checkGolden(t, name, data)
+		return
+	}
+	got, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join("testdata", "golden", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !samePixels(got, want) {
+		t.Errorf("%s differs from the golden file (run go test -update to update it)", name)
+	}
+}
+
+func samePixels(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.At(x, y) != b.At(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestGolden(t *testing.T) {
+	dir := t.TempDir()
+	images := []string{"cool.png", "green.gif", "warm.png"}
+	args := []string{
+		"-quiet", "-seed", "1", "-workers", "1", "-p", "1",
+		"-out-json", filepath.Join(dir, "{{basename .Path}}.json"),
+		"-out-png", filepath.Join(dir, "{{basename .Path}}.png"),
+	}
+	for _, name := range images {
+		args = append(args, filepath.Join("testdata", name))
+	}
+	checkGolden(t, "stdout.jsonl", run(t, args...))
+	for _, name := range images {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkGolden(t, name+".json", data)
+		checkGoldenPNG(t, name+".png", filepath.Join(dir, name+".png"))
+	}
+}
+
+func TestArchives(t *testing.T) {
+	dir := t.TempDir()
+	archives := []string{filepath.Join("testdata", "images.zip"), filepath.Join("testdata", "images.tar.gz")}
+	args := append([]string{
+		"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-format", "hex",
+		"-out-json", filepath.Join(dir, "{{basename .ArchivePath}}", "{{.EntryPath}}.json"),
+	}, archives...)
+	stdout := run(t, args...)
+	var want []string
+	for _, archive := range archives {
+		for _, entry := range []string{"a/cool.png", "b/warm.png"} {
+			path := archive + "!/" + entry
+			want = append(want, path)
+			data, err := ioutil.ReadFile(filepath.Join(dir, filepath.Base(archive), filepath.FromSlash(entry)+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc schema.Palette
+			if err := json.Unmarshal(data, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if doc.Path != path {
+				t.Errorf("palette JSON of %s has path %q", path, doc.Path)
+			}
+			// the palettes match those of the files outside of the archives
+			golden, err := ioutil.ReadFile(filepath.Join("testdata", "golden", filepath.Base(entry)+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var goldenDoc schema.Palette
+			if err := json.Unmarshal(golden, &goldenDoc); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(doc.Palette, goldenDoc.Palette) {
+				t.Errorf("palette of %s is %v, want %v", path, doc.Palette.Hex(), goldenDoc.Palette.Hex())
+			}
+		}
+	}
+	if got := hexPaths(stdout); !reflect.DeepEqual(got, want) {
+		t.Errorf("printed palettes of %v, want %v", got, want)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	cool, warm := filepath.Join("testdata", "cool.png"), filepath.Join("testdata", "warm.png")
+	missing := filepath.Join(dir, "missing.png")
+	// corrupt images fail in the workers, which with -p 1 process the images in order
+	bad1, bad2 := filepath.Join(dir, "bad1.png"), filepath.Join(dir, "bad2.png")
+	for _, path := range []string{bad1, bad2} {
+		if err := ioutil.WriteFile(path, []byte("not an image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		written []string
+	}{
+		{"ok", []string{cool, warm}, status.OK, []string{"cool.png.json", "warm.png.json"}},
+		{"some images fail", []string{cool, bad1, missing, warm}, status.Partial, []string{"cool.png.json", "warm.png.json"}},
+		{"all images fail", []string{bad1, missing, bad2}, status.Failure, nil},
+		{"fail fast", []string{"-fail-fast", bad1, cool, warm}, status.Failure, nil},
+		{"max errors reached", []string{"-max-errors", "2", bad1, cool, bad2, warm}, status.Failure, []string{"cool.png.json"}},
+		{"max errors not reached", []string{"-max-errors", "2", bad1, cool, warm}, status.Partial, []string{"cool.png.json", "warm.png.json"}},
+		{"invalid flags", []string{"-max-errors", "-1", cool}, status.Usage, nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, strconv.Itoa(i))
+			args := append([]string{"-quiet", "-p", "1", "-workers", "1", "-out-json", filepath.Join(out, "{{basename .Path}}.json")}, tt.args...)
+			code, _, stderr := runCode(t, args...)
+			if code != tt.want {
+				t.Errorf("extract-palette %q exited with %d, want %d\n%s", tt.args, code, tt.want, stderr)
+			}
+			if got := listFiles(t, out); !reflect.DeepEqual(got, tt.written) {
+				t.Errorf("extract-palette %q wrote %v, want %v", tt.args, got, tt.written)
+			}
+		})
+	}
+}
+
+func TestFormats(t *testing.T) {
+	for _, tt := range []struct{ format, golden string }{
+		{"json", "stdout.jsonl"},
+		{"hex", "stdout.txt"},
+		{"gpl", "stdout.gpl"},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			args := []string{"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-format", tt.format}
+			for _, name := range []string{"cool.png", "green.gif", "warm.png"} {
+				args = append(args, filepath.Join("testdata", name))
+			}
+			checkGolden(t, tt.golden, run(t, args...))
+		})
+	}
+}
+
+// hexPaths returns the paths of the lines written by -format hex
+func hexPaths(stdout []byte) []string {
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		paths = append(paths, strings.SplitN(line, "\t", 2)[0])
+	}
+	return paths
+}
+
+func TestStdin(t *testing.T) {
+	cool, warm := filepath.Join("testdata", "cool.png"), filepath.Join("testdata", "warm.png")
+	data, err := ioutil.ReadFile(cool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "copy.png"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// directories and archives read from stdin are expanded like arguments
+	zip := filepath.Join("testdata", "images.zip")
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  []string
+	}{
+		{"image", []string{"-"}, string(data), []string{"-"}},
+		{"lines", []string{"-stdin"}, cool + "\r\n\n" + warm + "\n", []string{cool, warm}},
+		{"lines without final newline", []string{"-stdin"}, cool + "\n" + warm, []string{cool, warm}},
+		{"nul separated", []string{"-0"}, cool + "\x00" + dir + "\x00" + zip + "\x00", []string{
+			cool, filepath.Join(dir, "copy.png"), zip + "!/a/cool.png", zip + "!/b/warm.png",
+		}},
+		{"arguments and paths", []string{"-stdin", warm}, cool + "\n", []string{warm, cool}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-format", "hex"}, tt.args...)
+			code, stdout, stderr := runStdin(t, []byte(tt.stdin), args...)
+			if code != status.OK {
+				t.Fatalf("extract-palette %q exited with %d\n%s", tt.args, code, stderr)
+			}
+			if got := hexPaths(stdout); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extract-palette %q with stdin %q: palettes of %v, want %v", tt.args, tt.stdin, got, tt.want)
+			}
+		})
+	}
+
+	// an image read from stdin has the same palette as the file
+	_, fromStdin, _ := runStdin(t, data, "-quiet", "-seed", "1", "-workers", "1", "-format", "hex", "-")
+	fromFile := run(t, "-quiet", "-seed", "1", "-workers", "1", "-format", "hex", cool)
+	if got, want := strings.SplitN(string(fromStdin), "\t", 2)[1], strings.SplitN(string(fromFile), "\t", 2)[1]; got != want {
+		t.Errorf("palette of an image read from stdin is %q, want %q", got, want)
+	}
+	if code, _, _ := runStdin(t, nil, "-quiet", "-stdin", "-"); code != status.Usage {
+		t.Errorf("reading both an image and paths from stdin exited with %d, want %d", code, status.Usage)
+	}
+}
@@ -0,0 +1,136 @@
+// Command palette-bench evaluates the quality of extracted palettes, so that algorithm changes can be
+// compared objectively. The benchmarks of the palette package are run with `go test -bench`.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+)
+
+var (
+	k      int
+	sample int
+	seed   int64
+
+	defaultImages = "docs/*.jpg"
+
+	errs status.Errors
+)
+
+func init() {
+	flag.IntVar(&k, "k", 8, "number of colors to extract")
+	flag.IntVar(&sample, "sample", 0, "use at most this many randomly chosen pixels per image (0 for all)")
+	flag.Int64Var(&seed, "seed", 0, "seed for random sampling and cluster initialization")
+	flag.Parse()
+	slog.SetDefault(logging.New(os.Stderr, logging.FormatText, slog.LevelInfo))
+}
+
+// qualityRecord is the fidelity of one palette, or the mean over all images if Path is empty
+type qualityRecord struct {
+	Path       string  `json:"path,omitempty"`
+	Algorithm  string  `json:"algorithm"`
+	ColorSpace string  `json:"colorSpace"`
+	K          int     `json:"k"`
+	Mean       float64 `json:"meanDeltaE"`
+	Max        float64 `json:"maxDeltaE"`
+	Seconds    float64 `json:"seconds"`
+	Images     int     `json:"images,omitempty"`
+}
+
+func main() {
+	paths := flag.Args()
+	if len(paths) == 0 {
+		paths, _ = filepath.Glob(defaultImages)
+	}
+	evaluateQuality(paths)
+	os.Exit(errs.ExitCode(len(paths)))
+}
+
+// evaluateQuality extracts palettes with every algorithm and color space, printing the fidelity
+// of each palette and then the mean over all images of each configuration
+func evaluateQuality(paths []string) {
+	enc := json.NewEncoder(os.Stdout)
+	type config struct {
+		algorithm string
+		space     palette.ColorSpace
+	}
+	var configs []config
+	for _, a := range palette.Algorithms {
+		for s := range palette.ColorSpaces {
+			configs = append(configs, config{a, palette.ColorSpace(s)})
+		}
+	}
+	totals := make([]qualityRecord, len(configs))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs.Fail(path, logging.StageRead, err)
+			continue
+		}
+		i, _, err := input.DecodeWith(data, input.DecodeOptions{})
+		if err != nil {
+			errs.Fail(path, logging.StageDecode, err)
+			continue
+		}
+		for j, c := range configs {
+			o := palette.Options{K: k, Algorithm: c.algorithm, ColorSpace: c.space, Sample: sample, Seed: seed}
+			start := time.Now()
+			result, err := palette.ExtractWithOptions(context.Background(), i, o)
+			if err != nil {
+				errs.Report(path, logging.StageExtract, err)
+				continue
+			}
+			seconds := time.Since(start).Seconds()
+			f, err := palette.MeasureFidelity(i, result.Palette)
+			if err != nil {
+				errs.Report(path, logging.StageExtract, err)
+				continue
+			}
+			record := qualityRecord{
+				Path:       path,
+				Algorithm:  c.algorithm,
+				ColorSpace: c.space.String(),
+				K:          k,
+				Mean:       f.Mean,
+				Max:        f.Max,
+				Seconds:    seconds,
+			}
+			encode(enc, record)
+			t := &totals[j]
+			t.Mean += f.Mean
+			t.Max = math.Max(t.Max, f.Max)
+			t.Seconds += seconds
+			t.Images++
+		}
+	}
+	for j, c := range configs {
+		t := totals[j]
+		if t.Images == 0 {
+			continue
+		}
+		t.Algorithm, t.ColorSpace, t.K = c.algorithm, c.space.String(), k
+		t.Mean /= float64(t.Images)
+		t.Seconds /= float64(t.Images)
+		encode(enc, t)
+	}
+}
+
+// encode writes a record to stdout, and exits if that fails
+func encode(enc *json.Encoder, record qualityRecord) {
+	if err := enc.Encode(record); err != nil {
+		status.Fatal("", fmt.Errorf("writing to stdout: %v", err))
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/sgreben/flagvar"
+	flagvarTemplate "github.com/sgreben/flagvar/template"
+	"github.com/sgreben/image-palette-tools/pkg/index"
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+	"github.com/sgreben/image-palette-tools/pkg/schema"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+	"github.com/sgreben/image-palette-tools/pkg/templates"
+)
+
+var (
+	k            int
+	n            int
+	indexPath    string
+	queryColors  string
+	queryPalette string
+	queryImage   string
+	inJSON       = flagvarTemplate.Template{Root: templateSettings}
+	colorSpace   = flagvar.Enum{Choices: palette.ColorSpaces, Value: "lab"}
+	maxParallel  int
+
+	templateSettings = templates.New()
+
+	algorithm         = flagvar.Enum{Choices: palette.Algorithms, Value: palette.AlgorithmKmeansPP}
+	extractColorSpace = flagvar.Enum{Choices: palette.ColorSpaces, Value: "hsl"}
+	sample            int
+	seed              int64
+	workers           int
+	options           palette.Options
+
+	noColorManagement bool
+	decodeOptions     input.DecodeOptions
+	quiet             bool
+	logFormat         = flagvar.Enum{Choices: logging.Formats, Value: logging.FormatText}
+
+	errs status.Errors
+)
+
+func init() {
+	flag.StringVar(&indexPath, "index", "palette-index.json", "path of the palette index file")
+	flag.IntVar(&k, "k", 8, "number of colors to extract from images that have no palette JSON")
+	flag.Var(&algorithm, "algorithm", fmt.Sprintf("clustering algorithm (%s)", algorithm.Help()))
+	flag.Var(&extractColorSpace, "extract-color-space", fmt.Sprintf("color space to cluster colors in when extracting palettes (%s)", extractColorSpace.Help()))
+	flag.IntVar(&sample, "sample", 0, "use at most this many randomly chosen pixels per image when extracting palettes (0 for all)")
+	flag.Int64Var(&seed, "seed", 0, "seed for random sampling and cluster initialization")
+	flag.IntVar(&workers, "workers", 0, "number of goroutines used to cluster the colors of a single image (0 for one per CPU)")
+	flag.IntVar(&n, "n", 10, "number of results to return")
+	flag.StringVar(&queryColors, "color", "", "query by a comma-separated list of colors (hex or CSS rgb(), hsl(), oklch())")
+	flag.StringVar(&queryPalette, "query-palette", "", "query by a palette file (JSON as written by -out-json, GIMP .gpl, or one color per line)")
+	flag.StringVar(&queryImage, "query-image", "", "query by an example image")
+	flag.Var(&inJSON, "in-json", "path to read palette JSON for each indexed image from (go template)")
+	flag.Var(&colorSpace, "color-space", fmt.Sprintf("color space for palette distances (%s)", colorSpace.Help()))
+	flag.IntVar(&maxParallel, "p", runtime.GOMAXPROCS(0), "number of images to index in parallel")
+	flag.BoolVar(&noColorManagement, "no-color-management", false, "do not convert images with an embedded ICC profile to sRGB or apply their EXIF orientation")
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors")
+	flag.Var(&logFormat, "log-format", fmt.Sprintf("format of the log written to stderr (%s)", logFormat.Help()))
+}
+
+// parseFlags parses and validates the command line and sets up logging and the options
+// palettes are extracted with, for indexing and for -query-image
+func parseFlags() {
+	flag.Parse()
+	level := slog.LevelInfo
+	if quiet {
+		level = slog.LevelWarn
+	}
+	slog.SetDefault(logging.New(os.Stderr, logFormat.Value, level))
+	decodeOptions = input.DecodeOptions{IgnoreICC: noColorManagement, IgnoreOrientation: noColorManagement}
+	extractSpace, _ := palette.ParseColorSpace(extractColorSpace.Value)
+	options = palette.Options{K: k, Algorithm: algorithm.Value, ColorSpace: extractSpace, Sample: sample, Seed: seed, Workers: workers}
+	if err := validateFlags(); err != nil {
+		status.UsageError(err)
+	}
+}
+
+func validateFlags() error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case maxParallel < 1:
+		return errors.New("-p must be positive")
+	case n < 1:
+		return errors.New("-n must be positive")
+	case flag.NArg() == 0 && queryColors == "" && queryPalette == "" && queryImage == "":
+		return errors.New("nothing to do: give images to index, or a query with -color, -query-palette or -query-image")
+	}
+	return nil
+}
+
+// extractPalette extracts the palette of an image with the same options as extract-palette,
+// so that query images and indexed images are compared alike
+func extractPalette(path string) ([]color.RGBA, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, logging.WithStage(logging.StageRead, err)
+	}
+	i, typ, err := input.DecodeWith(data, decodeOptions)
+	if err != nil {
+		return nil, logging.WithStage(logging.StageDecode, err)
+	}
+	slog.Info("loaded", "path", path, "format", typ, "width", i.Bounds().Dx(), "height", i.Bounds().Dy())
+	result, err := palette.ExtractWithOptions(context.Background(), i, options)
+	if err != nil {
+		return nil, err
+	}
+	return result.Palette, nil
+}
+
+func readPaletteJSON(path string) (string, []color.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	doc, err := schema.ReadPalette(f)
+	if err != nil {
+		return "", nil, err
+	}
+	return doc.Path, doc.Palette, nil
+}
+
+// loadPalette returns the palette for an index argument, which is either a palette JSON file or an image
+func loadPalette(path string) (string, []color.RGBA, error) {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		imagePath, p, err := readPaletteJSON(path)
+		if err != nil {
+			return "", nil, err
+		}
+		if imagePath == "" {
+			imagePath = path
+		}
+		return imagePath, p, nil
+	}
+	if inJSON.Value != nil {
+		b := bytes.NewBuffer(nil)
+		err := inJSON.Value.Execute(b, map[string]interface{}{
+			"Path": path,
+			"K":    k,
+		})
+		if err != nil {
+			return "", nil, logging.WithStage(logging.StageTemplate, err)
+		}
+		if _, p, err := readPaletteJSON(b.String()); err == nil && len(p) > 0 {
+			slog.Info("loading", "path", path, "input", b.String())
+			return path, p, nil
+		}
+	}
+	p, err := extractPalette(path)
+	return path, p, err
+}
+
+func buildIndex(ix *index.Index, paths []string) {
+	var wg sync.WaitGroup
+	work := make(chan string, maxParallel)
+	wg.Add(maxParallel)
+	for i := 0; i < maxParallel; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				imagePath, p, err := loadPalette(path)
+				if err != nil {
+					errs.Fail(path, logging.StageExtract, err)
+					continue
+				}
+				ix.Add(imagePath, p)
+			}
+		}()
+	}
+	for _, path := range paths {
+		work <- path
+	}
+	close(work)
+	wg.Wait()
+}
+
+func main() {
+	parseFlags()
+	space, _ := palette.ParseColorSpace(colorSpace.Value)
+
+	ix, err := index.Load(indexPath)
+	if err != nil {
+		status.Fatal(indexPath, err)
+	}
+
+	if flag.NArg() > 0 {
+		buildIndex(ix, flag.Args())
+		slog.Info("writing index", "output", indexPath, "entries", len(ix.Entries))
+		if err := ix.Save(indexPath); err != nil {
+			status.Fatal(indexPath, err)
+		}
+	}
+
+	var distance func(p []color.RGBA) float64
+	switch {
+	case queryColors != "":
+		var query palette.Palette
+		if err := query.UnmarshalText([]byte(queryColors)); err != nil {
+			status.UsageError(fmt.Errorf("-color: %v", err))
+		}
+		distance = func(p []color.RGBA) float64 { return palette.CoverDistance(query, p, space) }
+	case queryPalette != "":
+		query, err := palette.ReadFile(queryPalette)
+		if err != nil {
+			status.Fatal(queryPalette, err)
+		}
+		distance = func(p []color.RGBA) float64 { return palette.MatchDistance(query, p, space) }
+	case queryImage != "":
+		query, err := extractPalette(queryImage)
+		if err != nil {
+			status.Fatal(queryImage, err)
+		}
+		distance = func(p []color.RGBA) float64 { return palette.MatchDistance(query, p, space) }
+	default:
+		os.Exit(errs.ExitCode(flag.NArg()))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range ix.Search(n, distance) {
+		if err := enc.Encode(r); err != nil {
+			status.Fatal("", fmt.Errorf("writing to stdout: %v", err))
+		}
+	}
+	os.Exit(errs.ExitCode(flag.NArg()))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/index"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/golden")
+
+// runMainEnv is set in the environment of the test binary when it is run as the command
+const runMainEnv = "SEARCH_BY_PALETTE_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(status.OK)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs the command with `args` in a subprocess and returns its exit code and stdout
+func run(t *testing.T, args ...string) (int, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if e, ok := err.(*exec.ExitError); ok {
+		t.Logf("search-by-palette %q exited with %d\n%s", args, e.ExitCode(), stderr.Bytes())
+		return e.ExitCode(), out
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, out
+}
+
+// checkGolden compares `got` with the golden file `name`, or updates it with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file (run go test -update to update it)\ngot:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+var images = []string{"cool1.png", "cool2.png", "green1.png", "green2.png", "warm1.png", "warm2.png"}
+
+// indexImages indexes the test images into a new index and returns its path
+func indexImages(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "index.json")
+	args := []string{"-quiet", "-seed", "1", "-workers", "1", "-p", "1", "-k", "4", "-index", path}
+	for _, name := range images {
+		args = append(args, filepath.Join("testdata", name))
+	}
+	if code, _ := run(t, args...); code != status.OK {
+		t.Fatalf("indexing exited with %d", code)
+	}
+	return path
+}
+
+func TestGolden(t *testing.T) {
+	ix := indexImages(t)
+	data, err := ioutil.ReadFile(ix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "index.json", data)
+	tests := []struct {
+		name  string
+		query []string
+		// the color theme of the top two results
+		want string
+	}{
+		{"color", []string{"-color", "#3060c0,#a0d0f0"}, "cool"},
+		{"palette", []string{"-query-palette", filepath.Join("testdata", "query.txt")}, "warm"},
+		{"image", []string{"-query-image", filepath.Join("testdata", "green1.png")}, "green"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-quiet", "-seed", "1", "-workers", "1", "-k", "4", "-index", ix, "-n", "4"}, tt.query...)
+			code, stdout := run(t, args...)
+			if code != status.OK {
+				t.Fatalf("exited with %d", code)
+			}
+			checkGolden(t, tt.name+".jsonl", stdout)
+			var results []index.Result
+			dec := json.NewDecoder(bytes.NewReader(stdout))
+			for dec.More() {
+				var r index.Result
+				if err := dec.Decode(&r); err != nil {
+					t.Fatal(err)
+				}
+				results = append(results, r)
+			}
+			if len(results) != 4 {
+				t.Fatalf("%d results, want 4", len(results))
+			}
+			for i, r := range results {
+				if i > 0 && r.Distance < results[i-1].Distance {
+					t.Errorf("result %d has distance %v, less than the previous %v", i, r.Distance, results[i-1].Distance)
+				}
+				if theme := filepath.Join("testdata", tt.want); (i < 2) != strings.HasPrefix(r.Path, theme) {
+					t.Errorf("result %d is %s, want the %s images first", i, r.Path, tt.want)
+				}
+			}
+			if tt.name == "image" && (results[0].Path != filepath.Join("testdata", "green1.png") || results[0].Distance != 0) {
+				t.Errorf("the query image itself has distance %v, want 0", results[0].Distance)
+			}
+		})
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	ix := indexImages(t)
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.png")
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"some images missing", []string{"-index", filepath.Join(dir, "a.json"), filepath.Join("testdata", "cool1.png"), missing}, status.Partial},
+		{"invalid color", []string{"-index", ix, "-color", "#zz"}, status.Usage},
+		{"no workers", []string{"-index", ix, "-p", "0", "-color", "#fff"}, status.Usage},
+		{"nothing to do", []string{"-index", ix}, status.Usage},
+		{"all images missing", []string{"-index", filepath.Join(dir, "b.json"), missing}, status.Failure},
+		{"query palette missing", []string{"-index", ix, "-query-palette", missing}, status.Failure},
+		{"query image missing", []string{"-index", ix, "-query-image", missing}, status.Failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, _ := run(t, append([]string{"-quiet", "-k", "4"}, tt.args...)...); code != tt.want {
+				t.Errorf("search-by-palette %q exited with %d, want %d", tt.args, code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/sgreben/flagvar"
+	flagvarTemplate "github.com/sgreben/flagvar/template"
+	"github.com/sgreben/image-palette-tools/pkg/input"
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+	"github.com/sgreben/image-palette-tools/pkg/output"
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+	"github.com/sgreben/image-palette-tools/pkg/status"
+	"github.com/sgreben/image-palette-tools/pkg/templates"
+)
+
+var (
+	k           int
+	paletteFile string
+	styleImage  string
+	colorSpace  = flagvar.Enum{Choices: palette.ColorSpaces, Value: "lab"}
+	sharpness   float64
+	outPng      = flagvarTemplate.Template{Root: templateSettings}
+	maxParallel int
+
+	templateSettings = templates.New()
+
+	printBuffer = 1024
+
+	algorithm         = flagvar.Enum{Choices: palette.Algorithms, Value: palette.AlgorithmKmeansPP}
+	extractColorSpace = flagvar.Enum{Choices: palette.ColorSpaces, Value: "hsl"}
+	sample            int
+	seed              int64
+	workers           int
+	options           palette.Options
+
+	noColorManagement bool
+	decodeOptions     input.DecodeOptions
+	quiet             bool
+	logFormat         = flagvar.Enum{Choices: logging.Formats, Value: logging.FormatText}
+	overwrite         bool
+	skipExisting      bool
+	noClobber         bool
+	outputs           output.Writer
+
+	errs status.Errors
+)
+
+func init() {
+	flag.IntVar(&k, "k", 8, "number of colors to extract")
+	flag.Var(&algorithm, "algorithm", fmt.Sprintf("clustering algorithm (%s)", algorithm.Help()))
+	flag.Var(&extractColorSpace, "extract-color-space", fmt.Sprintf("color space to cluster colors in when extracting palettes (%s)", extractColorSpace.Help()))
+	flag.IntVar(&sample, "sample", 0, "use at most this many randomly chosen pixels per image when extracting palettes (0 for all)")
+	flag.Int64Var(&seed, "seed", 0, "seed for random sampling and cluster initialization")
+	flag.IntVar(&workers, "workers", 0, "number of goroutines used to cluster the colors of a single image (0 for one per CPU)")
+	flag.StringVar(&styleImage, "style", "", "path of an image whose palette to transfer")
+	flag.StringVar(&paletteFile, "palette", "", "path of a palette file to transfer instead of -style (JSON as written by -out-json, GIMP .gpl, or one color per line)")
+	flag.Var(&colorSpace, "color-space", fmt.Sprintf("color space for matching pixels to palette colors (%s)", colorSpace.Help()))
+	flag.Float64Var(&sharpness, "sharpness", 2, "how strongly pixels follow their nearest palette color (higher is harder)")
+	flag.IntVar(&maxParallel, "p", runtime.GOMAXPROCS(0), "number of images to process in parallel")
+	flag.Var(&outPng, "out-png", "path of output image (PNG) (go template)")
+	flag.BoolVar(&noColorManagement, "no-color-management", false, "do not convert images with an embedded ICC profile to sRGB or apply their EXIF orientation")
+	flag.BoolVar(&overwrite, "overwrite", false, "replace output files that already exist (the default)")
+	flag.BoolVar(&skipExisting, "skip-existing", false, "do not write output files that already exist")
+	flag.BoolVar(&noClobber, "no-clobber", false, "do not write output files that already exist, and log an error for each")
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors")
+	flag.Var(&logFormat, "log-format", fmt.Sprintf("format of the log written to stderr (%s)", logFormat.Help()))
+}
+
+// parseFlags parses and validates the command line and sets up logging and the options
+// used to extract the source and -style palettes
+func parseFlags() {
+	flag.Parse()
+	level := slog.LevelInfo
+	if quiet {
+		level = slog.LevelWarn
+	}
+	slog.SetDefault(logging.New(os.Stderr, logFormat.Value, level))
+	decodeOptions = input.DecodeOptions{IgnoreICC: noColorManagement, IgnoreOrientation: noColorManagement}
+	extractSpace, _ := palette.ParseColorSpace(extractColorSpace.Value)
+	options = palette.Options{K: k, Algorithm: algorithm.Value, ColorSpace: extractSpace, Sample: sample, Seed: seed, Workers: workers}
+	if err := validateFlags(); err != nil {
+		status.UsageError(err)
+	}
+	switch {
+	case skipExisting:
+		outputs.Policy = output.SkipExisting
+	case noClobber:
+		outputs.Policy = output.NoClobber
+	}
+}
+
+func validateFlags() error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case maxParallel < 1:
+		return errors.New("-p must be positive")
+	case overwrite && skipExisting, overwrite && noClobber, skipExisting && noClobber:
+		return errors.New("only one of -overwrite, -skip-existing and -no-clobber may be given")
+	case paletteFile == "" && styleImage == "":
+		return errors.New("no palette to transfer, use -style or -palette")
+	case flag.NArg() == 0:
+		return errors.New("no images given")
+	}
+	return nil
+}
+
+func loadImage(path string) (image.Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, logging.WithStage(logging.StageRead, err)
+	}
+	i, typ, err := input.DecodeWith(data, decodeOptions)
+	if err != nil {
+		return nil, logging.WithStage(logging.StageDecode, err)
+	}
+	slog.Info("loaded", "path", path, "format", typ, "width", i.Bounds().Dx(), "height", i.Bounds().Dy())
+	return i, nil
+}
+
+func writeOutPng(sourcePath string, source, target []color.RGBA, i image.Image) error {
+	b := bytes.NewBuffer(nil)
+	err := outPng.Value.Execute(b, map[string]interface{}{
+		"Path":    sourcePath,
+		"K":       k,
+		"Palette": source,
+		"Target":  target,
+	})
+	if err != nil {
+		return logging.WithStage(logging.StageTemplate, err)
+	}
+	return outputs.Write(b.String(), func(w io.Writer) error { return png.Encode(w, i) })
+}
+
+// extract extracts a palette of -k colors from `i`, with the same options as extract-palette
+func extract(i image.Image) ([]color.RGBA, error) {
+	result, err := palette.ExtractWithOptions(context.Background(), i, options)
+	if err != nil {
+		return nil, err
+	}
+	return result.Palette, nil
+}
+
+func main() {
+	parseFlags()
+	space, _ := palette.ParseColorSpace(colorSpace.Value)
+
+	var target []color.RGBA
+	switch {
+	case paletteFile != "":
+		p, err := palette.ReadFile(paletteFile)
+		if err != nil {
+			status.Fatal(paletteFile, err)
+		}
+		target = p
+	case styleImage != "":
+		i, err := loadImage(styleImage)
+		if err != nil {
+			status.Fatal(styleImage, err)
+		}
+		p, err := extract(i)
+		if err != nil {
+			status.Fatal(styleImage, err)
+		}
+		target = p
+	}
+	slog.Info("transferring palette", "palette", palette.Palette(target).Hex())
+	if outPng.Value == nil {
+		slog.Warn("no output specified, use -out-png")
+	}
+
+	print := make(chan interface{}, printBuffer)
+	var printWg sync.WaitGroup
+
+	printWg.Add(1)
+	go func() {
+		enc := json.NewEncoder(os.Stdout)
+		defer printWg.Done()
+		for obj := range print {
+			if err := enc.Encode(obj); err != nil {
+				errs.Report("-", logging.StageWrite, err)
+			}
+		}
+	}()
+
+	var workWg sync.WaitGroup
+	work := make(chan string, maxParallel)
+	workWg.Add(maxParallel)
+	for i := 0; i < maxParallel; i++ {
+		go func() {
+			defer workWg.Done()
+			for path := range work {
+				i, err := loadImage(path)
+				if err != nil {
+					errs.Fail(path, logging.StageRead, err)
+					continue
+				}
+				source, err := extract(i)
+				if err != nil {
+					errs.Fail(path, logging.StageExtract, err)
+					continue
+				}
+				out, err := palette.Transfer(source, target, i, space, sharpness)
+				if err != nil {
+					errs.Fail(path, logging.StageApply, err)
+					continue
+				}
+				if outPng.Value != nil {
+					if err := writeOutPng(path, source, target, out); err != nil {
+						errs.Report(path, logging.StageWrite, err)
+					}
+				}
+				print <- map[string]interface{}{
+					"path":    path,
+					"palette": palette.Palette(source),
+					"target":  palette.Palette(target),
+				}
+			}
+		}()
+	}
+	for _, path := range flag.Args() {
+		work <- path
+	}
+	close(work)
+	workWg.Wait()
+	close(print)
+	printWg.Wait()
+	os.Exit(errs.ExitCode(flag.NArg()))
+}
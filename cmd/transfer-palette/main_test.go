@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/status"
+)
+
+// runMainEnv is set in the environment of the test binary when it is run as the command
+const runMainEnv = "TRANSFER_PALETTE_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(status.OK)
+	}
+	os.Exit(m.Run())
+}
+
+// run runs the command with `args` in a subprocess and returns its exit code and stderr
+func run(t *testing.T, args ...string) (int, []byte) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode(), stderr.Bytes()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0, stderr.Bytes()
+}
+
+var (
+	dark  = color.NRGBA{20, 20, 30, 255}
+	light = color.NRGBA{230, 230, 210, 255}
+)
+
+// writeImage writes a PNG to `path` whose left half is dark and right half light,
+// with the bottom row translucent
+func writeImage(t *testing.T, path string) {
+	t.Helper()
+	i := image.NewNRGBA(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			c := dark
+			if x >= 4 {
+				c = light
+			}
+			if y == 3 {
+				c.A = 100
+			}
+			i.SetNRGBA(x, y, c)
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, i); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// distance returns the squared RGB distance of two colors
+func distance(a, b color.NRGBA) int {
+	dr, dg, db := int(a.R)-int(b.R), int(a.G)-int(b.G), int(a.B)-int(b.B)
+	return dr*dr + dg*dg + db*db
+}
+
+func TestTransfer(t *testing.T) {
+	dir := t.TempDir()
+	img, pal := filepath.Join(dir, "in.png"), filepath.Join(dir, "palette.txt")
+	writeImage(t, img)
+	if err := ioutil.WriteFile(pal, []byte("#f0e0ff\n#102060\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.png")
+	if code, stderr := run(t, "-quiet", "-k", "2", "-seed", "1", "-palette", pal, "-out-png", out, img); code != status.OK {
+		t.Fatalf("transfer-palette exited with %d\n%s", code, stderr)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the dark half takes the dark target color and the light half the light one, keeping the alpha
+	darkTarget, lightTarget := color.NRGBA{0x10, 0x20, 0x60, 255}, color.NRGBA{0xf0, 0xe0, 0xff, 255}
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			want, other := darkTarget, lightTarget
+			if x >= 4 {
+				want, other = lightTarget, darkTarget
+			}
+			wantAlpha := uint8(255)
+			if y == 3 {
+				wantAlpha = 100
+			}
+			got := color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA)
+			if got.A != wantAlpha {
+				t.Errorf("pixel (%d,%d) has alpha %d, want %d", x, y, got.A, wantAlpha)
+			}
+			if d := distance(got, want); d > 40*40 || d >= distance(got, other) {
+				t.Errorf("pixel (%d,%d) = %v, want near %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	img, missing := filepath.Join(dir, "in.png"), filepath.Join(dir, "missing.png")
+	writeImage(t, img)
+	out := filepath.Join(dir, "out", "{{basename .Path}}")
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"ok", []string{"-style", img, "-out-png", out, img}, status.OK},
+		{"some images missing", []string{"-style", img, "-out-png", out, img, missing}, status.Partial},
+		{"template error", []string{"-style", img, "-out-png", "{{index .Target 100}}", img}, status.Partial},
+		{"no palette", []string{"-out-png", out, img}, status.Usage},
+		{"no workers", []string{"-style", img, "-p", "0", img}, status.Usage},
+		{"no images", []string{"-style", img}, status.Usage},
+		{"all images missing", []string{"-style", img, "-out-png", out, missing}, status.Failure},
+		{"style missing", []string{"-style", missing, img}, status.Failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code, stderr := run(t, append([]string{"-quiet", "-k", "2"}, tt.args...)...); code != tt.want {
+				t.Errorf("transfer-palette %q exited with %d, want %d\n%s", tt.args, code, tt.want, stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Package diskcache implements a persistent, content-addressed cache of extracted palettes.
+//
+// Entries are keyed by a hash of the image file's bytes together with the extraction options,
+// so edited images are never served stale palettes, and renamed or copied images still hit.
+package diskcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/sgreben/image-palette-tools/pkg/output"
+)
+
+// Cache is a directory of cached palettes. A nil *Cache is a valid, always-missing cache.
+type Cache struct {
+	Dir string
+
+	hits, misses, errors uint64
+}
+
+// Stats are the cache's hit, miss and error counts
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Errors uint64 `json:"errors"`
+}
+
+// New returns a cache stored in `dir`, creating it if necessary
+func New(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// Key returns the cache key for image file content and extraction options
+func Key(content []byte, options ...string) string {
+	h := sha256.New()
+	h.Write(content)
+	for _, o := range options {
+		h.Write([]byte{0})
+		h.Write([]byte(o))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+// GetValue decodes the JSON value cached under `key` into `v`
+func (c *Cache) GetValue(key string, v interface{}) bool {
+	if c == nil {
+		return false
+	}
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			atomic.AddUint64(&c.errors, 1)
+		}
+		atomic.AddUint64(&c.misses, 1)
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		atomic.AddUint64(&c.misses, 1)
+		return false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return true
+}
+
+// PutValue stores the JSON encoding of `v` under `key`
+func (c *Cache) PutValue(key string, v interface{}) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = output.Writer{}.WriteFile(c.path(key), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		atomic.AddUint64(&c.errors, 1)
+	}
+	return err
+}
+
+// Clear removes all cached palettes
+func (c *Cache) Clear() error {
+	if c == nil {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() && len(e.Name()) == 2 {
+			if err := os.RemoveAll(filepath.Join(c.Dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns the cache's hit, miss and error counts
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Errors: atomic.LoadUint64(&c.errors),
+	}
+}
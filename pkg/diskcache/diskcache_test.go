@@ -0,0 +1,72 @@
+package diskcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type value struct {
+	Palette []string `json:"palette"`
+}
+
+func TestGetPutValue(t *testing.T) {
+	c, err := New(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key([]byte("image"), "options")
+	var v value
+	if c.GetValue(key, &v) {
+		t.Fatal("GetValue hit in an empty cache")
+	}
+	want := value{Palette: []string{"#ff0000", "#00ff00"}}
+	if err := c.PutValue(key, want); err != nil {
+		t.Fatal(err)
+	}
+	if !c.GetValue(key, &v) || len(v.Palette) != 2 || v.Palette[0] != want.Palette[0] || v.Palette[1] != want.Palette[1] {
+		t.Fatalf("GetValue = %v, want %v", v, want)
+	}
+	if other := Key([]byte("image"), "other options"); c.GetValue(other, &v) {
+		t.Error("GetValue hit for different options")
+	}
+
+	// corrupt entries are misses, and are counted as errors
+	if err := ioutil.WriteFile(c.path(key), []byte("{"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetValue(key, &v) {
+		t.Error("GetValue hit for a corrupt entry")
+	}
+	if got, want := c.Stats(), (Stats{Hits: 1, Misses: 3, Errors: 1}); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.path(key)); !os.IsNotExist(err) {
+		t.Errorf("entry remains after Clear: %v", err)
+	}
+	if _, err := os.Stat(c.Dir); err != nil {
+		t.Errorf("cache directory removed by Clear: %v", err)
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	if err := c.PutValue("00", value{}); err != nil {
+		t.Error(err)
+	}
+	var v value
+	if c.GetValue("00", &v) {
+		t.Error("GetValue hit in a nil cache")
+	}
+	if err := c.Clear(); err != nil {
+		t.Error(err)
+	}
+	if c.Stats() != (Stats{}) {
+		t.Errorf("Stats = %+v, want zero", c.Stats())
+	}
+}
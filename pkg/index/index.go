@@ -0,0 +1,101 @@
+// Package index implements an on-disk index of image palettes for searching images by color.
+package index
+
+import (
+	"encoding/json"
+	"image/color"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sgreben/image-palette-tools/pkg/output"
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+)
+
+// Entry is an indexed image palette
+type Entry struct {
+	Path    string          `json:"path"`
+	Palette palette.Palette `json:"palette"`
+}
+
+// Index is a set of image palettes, keyed by image path
+type Index struct {
+	sync.Mutex
+	Entries []*Entry `json:"entries"`
+
+	byPath map[string]*Entry
+}
+
+// Result is a search result
+type Result struct {
+	*Entry
+	Distance float64 `json:"distance"`
+}
+
+// New returns an empty index
+func New() *Index {
+	return &Index{byPath: make(map[string]*Entry)}
+}
+
+// Load reads an index from a JSON file. A missing file yields an empty index.
+func Load(path string) (*Index, error) {
+	ix := New()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var stored struct {
+		Entries []*Entry `json:"entries"`
+	}
+	if err := json.NewDecoder(f).Decode(&stored); err != nil {
+		return nil, err
+	}
+	for _, e := range stored.Entries {
+		ix.Add(e.Path, e.Palette)
+	}
+	return ix, nil
+}
+
+// Save writes the index to a JSON file, replacing it atomically (see output.Writer)
+func (ix *Index) Save(path string) error {
+	ix.Lock()
+	defer ix.Unlock()
+	_, err := output.Writer{}.WriteFile(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(ix)
+	})
+	return err
+}
+
+// Add adds or replaces the palette of the image at `path`
+func (ix *Index) Add(path string, p []color.RGBA) {
+	ix.Lock()
+	defer ix.Unlock()
+	if e, ok := ix.byPath[path]; ok {
+		e.Palette = p
+		return
+	}
+	e := &Entry{Path: path, Palette: p}
+	ix.byPath[path] = e
+	ix.Entries = append(ix.Entries, e)
+}
+
+// Search returns the `n` entries nearest to a query according to `distance`, nearest first.
+// If `n` is not positive, all entries are returned.
+func (ix *Index) Search(n int, distance func(p []color.RGBA) float64) []Result {
+	ix.Lock()
+	defer ix.Unlock()
+	out := make([]Result, len(ix.Entries))
+	for i, e := range ix.Entries {
+		out[i] = Result{Entry: e, Distance: distance(e.Palette)}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Distance < out[j].Distance })
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
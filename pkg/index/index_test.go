@@ -0,0 +1,96 @@
+package index
+
+import (
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	ix := New()
+	ix.Add("a.jpg", []color.RGBA{red, green})
+	ix.Add("b.jpg", []color.RGBA{blue})
+	ix.Add("a.jpg", []color.RGBA{green})
+	if err := ix.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"entries":[{"path":"a.jpg","palette":["#00ff00"]},{"path":"b.jpg","palette":["#0000ff"]}]}` + "\n"
+	if string(data) != want {
+		t.Errorf("saved %s, want %s", data, want)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Entries, ix.Entries) {
+		t.Errorf("loaded %+v, want %+v", loaded.Entries, ix.Entries)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := Load(filepath.Join(dir, "missing.json"))
+	if err != nil || len(ix.Entries) != 0 {
+		t.Errorf("Load of missing file = %v, %v; want empty index", ix, err)
+	}
+	tests := []struct {
+		json string
+		want palette.Palette
+		ok   bool
+	}{
+		{`{"entries":[{"path":"a.jpg","palette":["#f00","rgb(0 255 0)"]}]}`, palette.Palette{red, green}, true},
+		{`{"entries":[{"path":"a.jpg","palette":["#f00","not a color"]}]}`, nil, false},
+		{`{"entries":[{"path":"a.jpg","palette":"#f00"}]}`, nil, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "index.json")
+		if err := ioutil.WriteFile(path, []byte(tt.json), 0666); err != nil {
+			t.Fatal(err)
+		}
+		ix, err := Load(path)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("Load(%s) succeeded", tt.json)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Load(%s): %v", tt.json, err)
+			continue
+		}
+		if len(ix.Entries) != 1 || !reflect.DeepEqual(ix.Entries[0].Palette, tt.want) {
+			t.Errorf("Load(%s) = %+v, want palette %v", tt.json, ix.Entries, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ix := New()
+	ix.Add("red.jpg", []color.RGBA{red})
+	ix.Add("green.jpg", []color.RGBA{green})
+	ix.Add("blue.jpg", []color.RGBA{blue})
+	query := []color.RGBA{blue}
+	distance := func(p []color.RGBA) float64 { return palette.MatchDistance(query, p, palette.ColorSpaceLab) }
+	results := ix.Search(2, distance)
+	if len(results) != 2 || results[0].Path != "blue.jpg" || results[0].Distance != 0 {
+		t.Fatalf("Search = %+v, want blue.jpg first with distance 0", results)
+	}
+	if all := ix.Search(0, distance); len(all) != 3 {
+		t.Errorf("Search(0) returned %d results, want 3", len(all))
+	}
+}
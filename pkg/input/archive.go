@@ -0,0 +1,209 @@
+package input
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ArchiveSeparator separates the path of an archive from the path of an entry inside it
+const ArchiveSeparator = "!/"
+
+// IsArchive returns whether a path names a supported archive (.zip, .tar, .tar.gz, .tgz)
+func IsArchive(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitPath splits a path of the form `archive.zip!/inner/path.jpg` into the archive path and the entry path.
+// For paths outside of archives, the archive path is empty and the entry path is the path itself.
+func SplitPath(path string) (archivePath, entryPath string) {
+	if i := strings.Index(path, ArchiveSeparator); i >= 0 && IsArchive(path[:i]) {
+		return path[:i], path[i+len(ArchiveSeparator):]
+	}
+	return "", path
+}
+
+// Archives reads images from zip and tar archives without unpacking them to disk.
+// Entries of zip archives are read on demand. Entries of tar archives, which can only be read
+// sequentially, are buffered in memory from the time they are listed until they are read or released,
+// so callers should process entries as they are listed rather than collect them first.
+type Archives struct {
+	sync.Mutex
+	buffered map[string][]byte
+}
+
+// NewArchives returns an empty archive reader
+func NewArchives() *Archives {
+	return &Archives{buffered: make(map[string][]byte)}
+}
+
+// Expand calls `fn` with the path (`archive!/entry`) of each image in the archive at `path`
+// whose entry path is accepted by `accept`
+func (a *Archives) Expand(path string, accept func(entryPath string) bool, fn func(path string)) error {
+	if strings.HasSuffix(strings.ToLower(path), ".zip") {
+		return a.expandZip(path, accept, fn)
+	}
+	return a.expandTar(path, accept, fn)
+}
+
+func (a *Archives) expandZip(path string, accept func(string) bool, fn func(string)) error {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if f.FileInfo().IsDir() || !accept(f.Name) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		header := make([]byte, 16)
+		n, _ := io.ReadFull(r, header)
+		r.Close()
+		if _, ok := SniffBytes(header[:n]); ok {
+			fn(path + ArchiveSeparator + f.Name)
+		}
+	}
+	return nil
+}
+
+func openTar(path string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(gz), f, nil
+	}
+	return tar.NewReader(f), f, nil
+}
+
+func (a *Archives) expandTar(path string, accept func(string) bool, fn func(string)) error {
+	tr, closer, err := openTar(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg || !accept(h.Name) {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if _, ok := SniffBytes(data); !ok {
+			continue
+		}
+		entryPath := path + ArchiveSeparator + h.Name
+		a.Lock()
+		a.buffered[entryPath] = data
+		a.Unlock()
+		fn(entryPath)
+	}
+}
+
+// Release drops the buffered data of the tar entry `path`, if it has not been read.
+// It is called for listed entries that are not read after all.
+func (a *Archives) Release(path string) {
+	a.Lock()
+	delete(a.buffered, path)
+	a.Unlock()
+}
+
+// Buffered returns the number of tar entries that are buffered and have been neither read nor released
+func (a *Archives) Buffered() int {
+	a.Lock()
+	defer a.Unlock()
+	return len(a.buffered)
+}
+
+// ReadFile reads a file, or an archive entry given as `archive!/entry`
+func (a *Archives) ReadFile(path string) ([]byte, error) {
+	archivePath, entryPath := SplitPath(path)
+	if archivePath == "" {
+		return ioutil.ReadFile(path)
+	}
+	a.Lock()
+	data, ok := a.buffered[path]
+	delete(a.buffered, path)
+	a.Unlock()
+	if ok {
+		return data, nil
+	}
+	if strings.HasSuffix(strings.ToLower(archivePath), ".zip") {
+		z, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		for _, f := range z.File {
+			if f.Name == entryPath {
+				r, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				return ioutil.ReadAll(r)
+			}
+		}
+		return nil, fmt.Errorf("%s: no such archive entry", path)
+	}
+	tr, closer, err := openTar(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: no such archive entry", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Name == entryPath {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+// ArchivePath returns the path of the archive containing `path`, or the empty string
+func ArchivePath(path string) string {
+	archivePath, _ := SplitPath(path)
+	return archivePath
+}
+
+// EntryPath returns the path of `path` inside its archive, or `path` itself if it is not inside an archive
+func EntryPath(path string) string {
+	_, entryPath := SplitPath(path)
+	return entryPath
+}
@@ -0,0 +1,163 @@
+package input
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gobwas/glob"
+)
+
+// The archive fixtures contain img/quadrants.bmp, img/nested/quadrants.webp, and two files
+// that are not images: img/fake.png and readme.txt
+var archives = []string{"quadrants.zip", "quadrants.tar.gz"}
+
+func TestArchivesExpand(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept func(entryPath string) bool
+		want   []string
+	}{
+		{"all", func(string) bool { return true }, []string{"img/quadrants.bmp", "img/nested/quadrants.webp"}},
+		{"accept", glob.MustCompile("**.bmp", '/').Match, []string{"img/quadrants.bmp"}},
+	}
+	for _, name := range archives {
+		path := filepath.Join("testdata", name)
+		for _, tt := range tests {
+			a := NewArchives()
+			var got []string
+			if err := a.Expand(path, tt.accept, func(p string) { got = append(got, p) }); err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, entry := range tt.want {
+				want = append(want, path+ArchiveSeparator+entry)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s/%s: listed %v, want %v", name, tt.name, got, want)
+			}
+			for _, p := range got {
+				data, err := a.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				i, _, err := DecodeWith(data, DecodeOptions{})
+				if err != nil {
+					t.Fatalf("%s: %v", p, err)
+				}
+				checkQuadrants(t, i)
+			}
+			if n := a.Buffered(); n != 0 {
+				t.Errorf("%s/%s: %d entries still buffered after reading all", name, tt.name, n)
+			}
+		}
+	}
+}
+
+func TestArchivesRelease(t *testing.T) {
+	a := NewArchives()
+	path := filepath.Join("testdata", "quadrants.tar.gz")
+	var listed []string
+	if err := a.Expand(path, func(string) bool { return true }, func(p string) { listed = append(listed, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if n := a.Buffered(); n != len(listed) {
+		t.Fatalf("%d entries buffered, want %d", n, len(listed))
+	}
+	for _, p := range listed {
+		a.Release(p)
+	}
+	if n := a.Buffered(); n != 0 {
+		t.Errorf("%d entries buffered after releasing all", n)
+	}
+	// released entries can still be read, from the archive
+	if _, err := a.ReadFile(listed[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestArchivesReadFile checks reading entries that were not listed, so that nothing is buffered:
+// zip archives are reopened for each entry, and tar archives are read up to the entry
+func TestArchivesReadFile(t *testing.T) {
+	a := NewArchives()
+	for _, name := range archives {
+		archive := filepath.Join("testdata", name)
+		for _, entry := range []string{"img/nested/quadrants.webp", "img/quadrants.bmp"} {
+			data, err := a.ReadFile(archive + ArchiveSeparator + entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i, _, err := DecodeWith(data, DecodeOptions{})
+			if err != nil {
+				t.Fatalf("%s: %v", entry, err)
+			}
+			checkQuadrants(t, i)
+		}
+		if _, err := a.ReadFile(archive + ArchiveSeparator + "img/missing.png"); err == nil {
+			t.Errorf("%s: reading a missing entry succeeded", name)
+		}
+	}
+	if _, err := a.ReadFile(filepath.Join("testdata", "quadrants.bmp")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExpandArchives(t *testing.T) {
+	// archives are expanded when given directly and when walking, with Include, Exclude
+	// and Select matched against their entries
+	archive := filepath.Join("testdata", "quadrants.zip")
+	tests := []struct {
+		name string
+		o    WalkOptions
+		want []string
+	}{
+		{"all", WalkOptions{}, []string{"img/quadrants.bmp", "img/nested/quadrants.webp"}},
+		{"include", WalkOptions{Include: globs("**/nested/*")}, []string{"img/nested/quadrants.webp"}},
+		{"exclude", WalkOptions{Exclude: globs("**/nested/*")}, []string{"img/quadrants.bmp"}},
+		{"select", WalkOptions{Select: func(path string) bool { return path == archive+ArchiveSeparator+"img/quadrants.bmp" }}, []string{"img/quadrants.bmp"}},
+	}
+	for _, tt := range tests {
+		tt.o.Archives = NewArchives()
+		var got []string
+		if err := Expand(archive, tt.o, func(p string) { got = append(got, EntryPath(p)) }); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expanded to %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// without Archives, an archive is passed on as a file
+	var got []string
+	if err := Expand(archive, WalkOptions{}, func(p string) { got = append(got, p) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{archive}) {
+		t.Errorf("expanded without Archives to %v, want %v", got, []string{archive})
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	tests := []struct {
+		path           string
+		archive, entry string
+	}{
+		{"photos/a.zip!/img/b.jpg", "photos/a.zip", "img/b.jpg"},
+		{"a.TAR.GZ!/b.png", "a.TAR.GZ", "b.png"},
+		{"a.tgz!/x!/b.png", "a.tgz", "x!/b.png"},
+		{"a.tar!/b.png", "a.tar", "b.png"},
+		// not an archive
+		{"wow!/b.png", "", "wow!/b.png"},
+		{"photos/b.jpg", "", "photos/b.jpg"},
+	}
+	for _, tt := range tests {
+		if archive, entry := SplitPath(tt.path); archive != tt.archive || entry != tt.entry {
+			t.Errorf("SplitPath(%q) = %q, %q; want %q, %q", tt.path, archive, entry, tt.archive, tt.entry)
+		}
+		if got := ArchivePath(tt.path); got != tt.archive {
+			t.Errorf("ArchivePath(%q) = %q, want %q", tt.path, got, tt.archive)
+		}
+		if got := EntryPath(tt.path); got != tt.entry {
+			t.Errorf("EntryPath(%q) = %q, want %q", tt.path, got, tt.entry)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package input
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+)
+
+// DecodeFrames decodes all frames of an image.
+// Frames of animated GIFs are composited onto the full canvas, honoring their disposal methods;
+// other formats yield a single frame.
+func DecodeFrames(r io.Reader) ([]image.Image, string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		i, typ, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, typ, err
+		}
+		return []image.Image{i}, typ, nil
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, "gif", err
+	}
+	return compositeGIF(g), "gif", nil
+}
+
+// DecodeFramesWith decodes all frames of an image like DecodeFrames, and converts each frame
+// to sRGB and applies the image's EXIF orientation like DecodeWith.
+func DecodeFramesWith(data []byte, o DecodeOptions) ([]image.Image, string, error) {
+	frames, typ, err := DecodeFrames(bytes.NewReader(data))
+	if err != nil || (o.IgnoreICC && o.IgnoreOrientation) {
+		return frames, typ, err
+	}
+	m := readMetadata(data)
+	for j, i := range frames {
+		frames[j] = o.apply(i, m)
+	}
+	return frames, typ, nil
+}
+
+func compositeGIF(g *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() && len(g.Image) > 0 {
+		bounds = g.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(bounds)
+	out := make([]image.Image, len(g.Image))
+	for j, frame := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(gif.DisposalNone)
+		if j < len(g.Disposal) {
+			disposal = g.Disposal[j]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		snapshot := image.NewRGBA(bounds)
+		copy(snapshot.Pix, canvas.Pix)
+		out[j] = snapshot
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return out
+}
+
+// SampleFrames returns every `step`-th frame, thinned out evenly to at most `max` frames if `max` is positive
+func SampleFrames(frames []image.Image, step, max int) []image.Image {
+	if step < 1 {
+		step = 1
+	}
+	var out []image.Image
+	for j := 0; j < len(frames); j += step {
+		out = append(out, frames[j])
+	}
+	if max <= 0 || len(out) <= max {
+		return out
+	}
+	thinned := make([]image.Image, max)
+	for j := range thinned {
+		thinned[j] = out[j*len(out)/max]
+	}
+	return thinned
+}
@@ -0,0 +1,258 @@
+package input
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+var errICCUnsupported = errors.New("icc: unsupported profile")
+
+// iccProfile is a matrix/TRC ICC profile (RGB or gray) with an XYZ connection space
+type iccProfile struct {
+	gray   bool
+	trc    [3]func(float64) float64
+	matrix [3][3]float64 // device linear RGB to PCS XYZ (D50)
+}
+
+// xyzD50ToLinearSRGB is the Bradford-adapted XYZ (D50) to linear sRGB matrix
+var xyzD50ToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func parseICC(data []byte) (*iccProfile, error) {
+	if len(data) < 132 {
+		return nil, errICCUnsupported
+	}
+	colorSpace, pcs := string(data[16:20]), string(data[20:24])
+	if pcs != "XYZ " || (colorSpace != "RGB " && colorSpace != "GRAY") {
+		return nil, errICCUnsupported
+	}
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count; i++ {
+		e := 132 + 12*i
+		if e+12 > len(data) {
+			return nil, errICCUnsupported
+		}
+		offset := int(binary.BigEndian.Uint32(data[e+4 : e+8]))
+		size := int(binary.BigEndian.Uint32(data[e+8 : e+12]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errICCUnsupported
+		}
+		tags[string(data[e:e+4])] = data[offset : offset+size]
+	}
+
+	p := &iccProfile{gray: colorSpace == "GRAY"}
+	if p.gray {
+		trc, err := parseTRC(tags["kTRC"])
+		if err != nil {
+			return nil, err
+		}
+		p.trc = [3]func(float64) float64{trc, trc, trc}
+		return p, nil
+	}
+	for c, name := range []string{"r", "g", "b"} {
+		trc, err := parseTRC(tags[name+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		p.trc[c] = trc
+		xyz := tags[name+"XYZ"]
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, errICCUnsupported
+		}
+		for row := 0; row < 3; row++ {
+			p.matrix[row][c] = s15Fixed16(xyz[8+4*row:])
+		}
+	}
+	return p, nil
+}
+
+func parseTRC(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errICCUnsupported
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+2*n {
+			return nil, errICCUnsupported
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			if i < 0 {
+				return table[0]
+			}
+			f := pos - float64(i)
+			return table[i]*(1-f) + table[i+1]*f
+		}, nil
+	case "para":
+		fn := binary.BigEndian.Uint16(tag[8:10])
+		numParams := []int{1, 3, 4, 5, 7}
+		if int(fn) >= len(numParams) || len(tag) < 12+4*numParams[fn] {
+			return nil, errICCUnsupported
+		}
+		var q [7]float64
+		for i := 0; i < numParams[fn]; i++ {
+			q[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := q[0], q[1], q[2], q[3], q[4], q[5], q[6]
+		switch fn {
+		case 0:
+			return func(x float64) float64 { return math.Pow(x, g) }, nil
+		case 1:
+			return func(x float64) float64 {
+				if x >= -b/a {
+					return math.Pow(a*x+b, g)
+				}
+				return 0
+			}, nil
+		case 2:
+			return func(x float64) float64 {
+				if x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			}, nil
+		case 3:
+			return func(x float64) float64 {
+				if x >= d {
+					return math.Pow(a*x+b, g)
+				}
+				return c * x
+			}, nil
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(a*x+b, g) + e
+			}
+			return c*x + f
+		}, nil
+	}
+	return nil, errICCUnsupported
+}
+
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func clampUnit(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// iccTransform converts 8-bit device colors to 8-bit sRGB using lookup tables
+type iccTransform struct {
+	gray   bool
+	linear [3][256]float64
+	matrix [3][3]float64 // device linear RGB to linear sRGB
+	encode [16384]uint8
+}
+
+func newICCTransform(p *iccProfile) *iccTransform {
+	t := &iccTransform{gray: p.gray}
+	for c := range t.linear {
+		for v := range t.linear[c] {
+			t.linear[c][v] = p.trc[c](float64(v) / 255)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				t.matrix[i][j] += xyzD50ToLinearSRGB[i][k] * p.matrix[k][j]
+			}
+		}
+	}
+	for i := range t.encode {
+		t.encode[i] = uint8(math.Round(255 * clampUnit(encodeSRGB(float64(i)/float64(len(t.encode)-1)))))
+	}
+	return t
+}
+
+func (t *iccTransform) convert(r, g, b uint8) (uint8, uint8, uint8) {
+	lookup := func(v float64) uint8 {
+		return t.encode[int(math.Round(clampUnit(v)*float64(len(t.encode)-1)))]
+	}
+	if t.gray {
+		y := lookup(t.linear[0][r])
+		return y, y, y
+	}
+	lr, lg, lb := t.linear[0][r], t.linear[1][g], t.linear[2][b]
+	m := &t.matrix
+	return lookup(m[0][0]*lr + m[0][1]*lg + m[0][2]*lb),
+		lookup(m[1][0]*lr + m[1][1]*lg + m[1][2]*lb),
+		lookup(m[2][0]*lr + m[2][1]*lg + m[2][2]*lb)
+}
+
+// isIdentity returns whether the transform leaves 8-bit colors (nearly) unchanged, as for sRGB profiles
+func (t *iccTransform) isIdentity() bool {
+	for r := 0; r < 256; r += 51 {
+		for g := 0; g < 256; g += 51 {
+			for b := 0; b < 256; b += 51 {
+				cr, cg, cb := t.convert(uint8(r), uint8(g), uint8(b))
+				if absDiff(cr, uint8(r)) > 1 || absDiff(cg, uint8(g)) > 1 || absDiff(cb, uint8(b)) > 1 {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// apply converts an image to sRGB. Paletted images stay paletted, with their color table converted.
+func (t *iccTransform) apply(i image.Image) image.Image {
+	convert := func(c color.Color) color.NRGBA {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		n.R, n.G, n.B = t.convert(n.R, n.G, n.B)
+		return n
+	}
+	if pi, ok := i.(*image.Paletted); ok {
+		out := *pi
+		out.Palette = make(color.Palette, len(pi.Palette))
+		for j, c := range pi.Palette {
+			out.Palette[j] = convert(c)
+		}
+		return &out
+	}
+	bounds := i.Bounds()
+	out := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			out.SetNRGBA(x, y, convert(i.At(x, y)))
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package input
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The ICC fixtures are 6x1 PNGs of the colors iccPixels with an embedded Display P3
+// (parametric sRGB curve) or Adobe RGB (1998) (gamma curve) matrix/TRC profile.
+var iccPixels = []color.NRGBA{
+	{200, 100, 50, 255}, {128, 128, 128, 255}, {30, 180, 220, 255}, {255, 255, 255, 255}, {90, 60, 160, 255}, {0, 0, 0, 255},
+}
+
+func TestDecodeICC(t *testing.T) {
+	tests := []struct {
+		file string
+		// computed with the published D65 conversion matrices to sRGB rather than the profile's
+		// D50 colorants, so that the chromatic adaptation is checked as well
+		want []color.NRGBA
+	}{
+		{"displayp3.png", []color.NRGBA{
+			{215, 93, 31, 255}, {128, 128, 128, 255}, {0, 183, 225, 255}, {255, 255, 255, 255}, {95, 58, 166, 255}, {0, 0, 0, 255},
+		}},
+		{"adobergb.png", []color.NRGBA{
+			{227, 100, 42, 255}, {129, 129, 129, 255}, {0, 181, 222, 255}, {255, 255, 255, 255}, {99, 57, 164, 255}, {0, 0, 0, 255},
+		}},
+	}
+	for _, tt := range tests {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		converted, _, err := DecodeWith(data, DecodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _, err := DecodeWith(data, DecodeOptions{IgnoreICC: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for x, want := range tt.want {
+			got := color.NRGBAModel.Convert(converted.At(x, 0)).(color.NRGBA)
+			if absDiff(got.R, want.R) > 2 || absDiff(got.G, want.G) > 2 || absDiff(got.B, want.B) > 2 || got.A != want.A {
+				t.Errorf("%s: pixel %v converted to %v, want %v", tt.file, iccPixels[x], got, want)
+			}
+			if got := color.NRGBAModel.Convert(raw.At(x, 0)).(color.NRGBA); got != iccPixels[x] {
+				t.Errorf("%s: pixel %v decoded as %v with IgnoreICC", tt.file, iccPixels[x], got)
+			}
+		}
+	}
+}
+
+// TestDecodeICCJPEG checks that profiles embedded in JPEG APP2 segments are applied
+func TestDecodeICCJPEG(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "displayp3.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := readMetadata(data).icc
+	p, err := parseICC(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform := newICCTransform(p)
+
+	i := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			i.SetNRGBA(x, y, color.NRGBA{200, 100, 50, 255})
+		}
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, i, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	segment := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	app2 := append([]byte{0xff, 0xe2, byte((len(segment) + 2) >> 8), byte(len(segment) + 2)}, segment...)
+	withProfile := append(append(append([]byte(nil), b.Bytes()[:2]...), app2...), b.Bytes()[2:]...)
+
+	raw, _, err := DecodeWith(withProfile, DecodeOptions{IgnoreICC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, _, err := DecodeWith(withProfile, DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := color.NRGBAModel.Convert(raw.At(8, 8)).(color.NRGBA)
+	r, g, bl := transform.convert(c.R, c.G, c.B)
+	if got := color.NRGBAModel.Convert(converted.At(8, 8)).(color.NRGBA); got != (color.NRGBA{r, g, bl, 255}) {
+		t.Errorf("JPEG pixel %v converted to %v, want %v", c, got, color.NRGBA{r, g, bl, 255})
+	}
+	if absDiff(r, 215) > 3 || absDiff(g, 93) > 3 || absDiff(bl, 31) > 3 {
+		t.Errorf("JPEG pixel %v converted to %v, want about {215 93 31}", c, []uint8{r, g, bl})
+	}
+}
+
+func TestParseICCUnsupported(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "displayp3.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := readMetadata(data).icc
+	cmyk := append([]byte(nil), profile...)
+	copy(cmyk[16:20], "CMYK")
+	truncated := profile[:140]
+	for name, p := range map[string][]byte{"cmyk": cmyk, "truncated": truncated, "empty": nil} {
+		if _, err := parseICC(p); err == nil {
+			t.Errorf("%s: parseICC succeeded", name)
+		}
+	}
+}
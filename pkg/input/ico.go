@@ -0,0 +1,127 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/image/bmp"
+)
+
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
+}
+
+type icoEntry struct {
+	Width, Height uint8
+	ColorCount    uint8
+	Reserved      uint8
+	Planes        uint16
+	BitCount      uint16
+	Size          uint32
+	Offset        uint32
+}
+
+var errICO = errors.New("ico: invalid format")
+
+// largestICOEntry returns the image data of the largest icon in an ICO file
+func largestICOEntry(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 {
+		return nil, errICO
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if count == 0 || len(data) < 6+16*count {
+		return nil, errICO
+	}
+	var best icoEntry
+	bestArea := -1
+	for i := 0; i < count; i++ {
+		var e icoEntry
+		if err := binary.Read(bytes.NewReader(data[6+16*i:]), binary.LittleEndian, &e); err != nil {
+			return nil, err
+		}
+		w, h := int(e.Width), int(e.Height)
+		if w == 0 {
+			w = 256
+		}
+		if h == 0 {
+			h = 256
+		}
+		if area := w * h; area > bestArea || (area == bestArea && e.BitCount > best.BitCount) {
+			best, bestArea = e, area
+		}
+	}
+	end := uint64(best.Offset) + uint64(best.Size)
+	if end > uint64(len(data)) {
+		return nil, errICO
+	}
+	return data[best.Offset:end], nil
+}
+
+// icoBMP turns the headerless DIB of an ICO entry into a BMP file
+func icoBMP(dib []byte) ([]byte, error) {
+	if len(dib) < 40 {
+		return nil, errICO
+	}
+	dib = append([]byte(nil), dib...)
+	headerSize := binary.LittleEndian.Uint32(dib[0:4])
+	height := int32(binary.LittleEndian.Uint32(dib[8:12]))
+	bitCount := binary.LittleEndian.Uint16(dib[14:16])
+	colorsUsed := binary.LittleEndian.Uint32(dib[32:36])
+	// the DIB height covers both the color bitmap and the transparency mask
+	binary.LittleEndian.PutUint32(dib[8:12], uint32(height/2))
+	paletteSize := uint32(0)
+	if bitCount <= 8 {
+		if colorsUsed == 0 {
+			colorsUsed = 1 << bitCount
+		}
+		paletteSize = 4 * colorsUsed
+	}
+	header := make([]byte, 14)
+	header[0], header[1] = 'B', 'M'
+	binary.LittleEndian.PutUint32(header[2:6], uint32(14+len(dib)))
+	binary.LittleEndian.PutUint32(header[10:14], 14+headerSize+paletteSize)
+	return append(header, dib...), nil
+}
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n"))
+}
+
+func decodeICO(r io.Reader) (image.Image, error) {
+	data, err := largestICOEntry(r)
+	if err != nil {
+		return nil, err
+	}
+	if isPNG(data) {
+		return png.Decode(bytes.NewReader(data))
+	}
+	b, err := icoBMP(data)
+	if err != nil {
+		return nil, err
+	}
+	return bmp.Decode(bytes.NewReader(b))
+}
+
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	data, err := largestICOEntry(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if isPNG(data) {
+		return png.DecodeConfig(bytes.NewReader(data))
+	}
+	b, err := icoBMP(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return bmp.DecodeConfig(bytes.NewReader(b))
+}
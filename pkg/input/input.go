@@ -0,0 +1,74 @@
+// Package input decodes the image formats supported by the tools.
+//
+// Importing it registers decoders for GIF, JPEG, PNG, BMP, TIFF, WebP and ICO images.
+package input
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"  // register GIF decoder
+	_ "image/jpeg" // register JPEG decoder
+	_ "image/png"  // register PNG decoder
+	"io"
+
+	_ "golang.org/x/image/bmp"  // register BMP decoder
+	_ "golang.org/x/image/tiff" // register TIFF decoder
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// Formats lists the names of the supported image formats
+var Formats = []string{"gif", "jpeg", "png", "bmp", "tiff", "webp", "ico"}
+
+// Decode decodes an image in any of the supported formats
+func Decode(r io.Reader) (image.Image, string, error) {
+	return image.Decode(r)
+}
+
+// DecodeOptions control the color management applied by DecodeWith
+type DecodeOptions struct {
+	// IgnoreICC disables the conversion of images with an embedded ICC profile to sRGB
+	IgnoreICC bool
+	// IgnoreOrientation disables applying the EXIF orientation
+	IgnoreOrientation bool
+}
+
+// Key returns a short string identifying the options, for use in cache keys
+func (o DecodeOptions) Key() string {
+	key := "cm"
+	if !o.IgnoreICC {
+		key += "+icc"
+	}
+	if !o.IgnoreOrientation {
+		key += "+orient"
+	}
+	return key
+}
+
+// DecodeWith decodes an image in any of the supported formats and, unless disabled by the options,
+// converts it to sRGB using its embedded matrix/TRC ICC profile and applies its EXIF orientation.
+// Unsupported ICC profiles (e.g. LUT-based or CMYK) are ignored.
+func DecodeWith(data []byte, o DecodeOptions) (image.Image, string, error) {
+	i, typ, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, typ, err
+	}
+	if o.IgnoreICC && o.IgnoreOrientation {
+		return i, typ, nil
+	}
+	return o.apply(i, readMetadata(data)), typ, nil
+}
+
+// apply converts an image to sRGB and orients it as described by its metadata, unless disabled
+func (o DecodeOptions) apply(i image.Image, m metadata) image.Image {
+	if !o.IgnoreICC && len(m.icc) > 0 {
+		if p, err := parseICC(m.icc); err == nil {
+			if t := newICCTransform(p); !t.isIdentity() {
+				i = t.apply(i)
+			}
+		}
+	}
+	if !o.IgnoreOrientation {
+		i = Orient(i, m.orientation)
+	}
+	return i
+}
@@ -0,0 +1,141 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata are 8x6 images of four solid quadrants (red, green, blue, white,
+// left to right, top to bottom), so that flipped or mirrored decodes are caught.
+// The ICO fixtures also contain a smaller 4x4 icon, which must not be chosen.
+var quadrants = []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 255}}
+
+const fixtureWidth, fixtureHeight = 8, 6
+
+func checkQuadrants(t *testing.T, i image.Image) {
+	t.Helper()
+	b := i.Bounds()
+	if b.Dx() != fixtureWidth || b.Dy() != fixtureHeight {
+		t.Fatalf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), fixtureWidth, fixtureHeight)
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			want := quadrants[x*2/fixtureWidth+2*(y*2/fixtureHeight)]
+			if got := color.RGBAModel.Convert(i.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA); got != want {
+				t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	tests := []struct {
+		file   string
+		format string
+	}{
+		{"quadrants.bmp", "bmp"},
+		{"quadrants.tiff", "tiff"},
+		{"quadrants.webp", "webp"},
+		{"quadrants-png.ico", "ico"},
+		{"quadrants-bmp.ico", "ico"},
+		{"quadrants-bmp32.ico", "ico"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format, ok := SniffBytes(data); !ok || format != tt.format {
+				t.Errorf("SniffBytes = %q, %v; want %q", format, ok, tt.format)
+			}
+			config, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("DecodeConfig: %v", err)
+			}
+			if format != tt.format || config.Width != fixtureWidth || config.Height != fixtureHeight {
+				t.Errorf("DecodeConfig = %s %dx%d, want %s %dx%d", format, config.Width, config.Height, tt.format, fixtureWidth, fixtureHeight)
+			}
+			i, format, err := Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("format = %q, want %q", format, tt.format)
+			}
+			checkQuadrants(t, i)
+		})
+	}
+}
+
+func TestDecodeICOInvalid(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "quadrants-png.ico"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{4, 6, 20, len(data) - 1} {
+		if _, _, err := Decode(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("Decode of %d-byte prefix succeeded", n)
+		}
+	}
+}
+
+// insertChunk returns the PNG `data` with a chunk inserted after the signature and the IHDR chunk
+func insertChunk(data []byte, typ string, content []byte) []byte {
+	chunk := make([]byte, 8, 12+len(content))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(content)))
+	copy(chunk[4:8], typ)
+	chunk = append(chunk, content...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	const ihdrEnd = 8 + 8 + 13 + 4
+	return append(append(append([]byte(nil), data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+// withOrientation returns a PNG of `i` with an eXIf chunk giving the EXIF orientation
+func withOrientation(t *testing.T, i image.Image, orientation uint16) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, i); err != nil {
+		t.Fatal(err)
+	}
+	exif := []byte("II*\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint16(exif[18:20], orientation)
+	return insertChunk(b.Bytes(), "eXIf", exif)
+}
+
+func TestDecodeFramesWithOrientation(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "quadrants.bmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, _, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// orientation 3 rotates by 180 degrees, so the white quadrant ends up top left
+	data = withOrientation(t, i, 3)
+	topLeft := func(o DecodeOptions) color.RGBA {
+		frames, _, err := DecodeFramesWith(data, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(frames) != 1 {
+			t.Fatalf("%d frames, want 1", len(frames))
+		}
+		b := frames[0].Bounds()
+		return color.RGBAModel.Convert(frames[0].At(b.Min.X, b.Min.Y)).(color.RGBA)
+	}
+	if got := topLeft(DecodeOptions{}); got != quadrants[3] {
+		t.Errorf("oriented top left = %v, want %v", got, quadrants[3])
+	}
+	if got := topLeft(DecodeOptions{IgnoreOrientation: true}); got != quadrants[0] {
+		t.Errorf("unoriented top left = %v, want %v", got, quadrants[0])
+	}
+}
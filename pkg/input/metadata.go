@@ -0,0 +1,149 @@
+package input
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"sort"
+)
+
+// metadata is the color management information embedded in an image file
+type metadata struct {
+	icc         []byte
+	orientation int
+}
+
+// readMetadata extracts the ICC profile and EXIF orientation from JPEG, PNG and WebP files
+func readMetadata(data []byte) (m metadata) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		return jpegMetadata(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return pngMetadata(data)
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && string(data[8:12]) == "WEBP":
+		return webpMetadata(data)
+	}
+	return
+}
+
+func jpegMetadata(data []byte) (m metadata) {
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			break
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			i++
+			continue
+		}
+		if marker == 0xd8 || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			i += 2
+			continue
+		}
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		switch {
+		case marker == 0xe2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")) && len(segment) > 14:
+			chunks = append(chunks, chunk{seq: segment[12], data: segment[14:]})
+		case marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			m.orientation = exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].seq < chunks[j].seq })
+	for _, c := range chunks {
+		m.icc = append(m.icc, c.data...)
+	}
+	return
+}
+
+func pngMetadata(data []byte) (m metadata) {
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		typ := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			break
+		}
+		chunk := data[i+8 : i+8+length]
+		switch typ {
+		case "iCCP":
+			if n := bytes.IndexByte(chunk, 0); n >= 0 && n+2 <= len(chunk) {
+				if r, err := zlib.NewReader(bytes.NewReader(chunk[n+2:])); err == nil {
+					m.icc, _ = ioutil.ReadAll(r)
+				}
+			}
+		case "eXIf":
+			m.orientation = exifOrientation(chunk)
+		case "IDAT", "IEND":
+			return
+		}
+		i += 12 + length
+	}
+	return
+}
+
+func webpMetadata(data []byte) (m metadata) {
+	for i := 12; i+8 <= len(data); {
+		typ := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		if length < 0 || i+8+length > len(data) {
+			break
+		}
+		chunk := data[i+8 : i+8+length]
+		switch typ {
+		case "ICCP":
+			m.icc = chunk
+		case "EXIF":
+			m.orientation = exifOrientation(bytes.TrimPrefix(chunk, []byte("Exif\x00\x00")))
+		}
+		i += 8 + length + length%2
+	}
+	return
+}
+
+// exifOrientation reads the orientation tag (0x0112) from the first IFD of TIFF-structured EXIF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for j := 0; j < count; j++ {
+		e := ifd + 2 + 12*j
+		if e+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[e:e+2]) == 0x0112 {
+			o := int(order.Uint16(tiff[e+8 : e+10]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package input
+
+import "image"
+
+// Orient transforms an image according to an EXIF orientation value (1-8).
+// Other values leave the image unchanged.
+func Orient(i image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return i
+	}
+	b := i.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	// source returns the source pixel shown at destination (x, y)
+	source := func(x, y int) (int, int) {
+		switch orientation {
+		case 2:
+			return w - 1 - x, y
+		case 3:
+			return w - 1 - x, h - 1 - y
+		case 4:
+			return x, h - 1 - y
+		case 5:
+			return y, x
+		case 6:
+			return y, h - 1 - x
+		case 7:
+			return w - 1 - y, h - 1 - x
+		}
+		return w - 1 - y, x
+	}
+	if pi, ok := i.(*image.Paletted); ok {
+		out := image.NewPaletted(image.Rect(0, 0, dw, dh), pi.Palette)
+		for y := 0; y < dh; y++ {
+			for x := 0; x < dw; x++ {
+				sx, sy := source(x, y)
+				out.SetColorIndex(x, y, pi.ColorIndexAt(b.Min.X+sx, b.Min.Y+sy))
+			}
+		}
+		return out
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			out.Set(x, y, i.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package input
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+var magics = []struct {
+	format string
+	offset int
+	magic  string
+}{
+	{"jpeg", 0, "\xff\xd8\xff"},
+	{"png", 0, "\x89PNG\r\n\x1a\n"},
+	{"gif", 0, "GIF87a"},
+	{"gif", 0, "GIF89a"},
+	{"bmp", 0, "BM"},
+	{"tiff", 0, "II*\x00"},
+	{"tiff", 0, "MM\x00*"},
+	{"webp", 8, "WEBP"},
+	{"ico", 0, "\x00\x00\x01\x00"},
+}
+
+// SniffBytes returns the image format of data by its magic bytes
+func SniffBytes(data []byte) (string, bool) {
+	for _, m := range magics {
+		if len(data) < m.offset {
+			continue
+		}
+		if bytes.HasPrefix(data[m.offset:], []byte(m.magic)) {
+			if m.format == "webp" && !bytes.HasPrefix(data, []byte("RIFF")) {
+				continue
+			}
+			return m.format, true
+		}
+	}
+	return "", false
+}
+
+// Sniff returns the image format of a file by its magic bytes
+func Sniff(path string) (string, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	return SniffBytes(header[:n])
+}
@@ -0,0 +1,398 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+)
+
+// ErrStreamUnsupported is returned by StreamPNG for images it cannot decode incrementally
+// (interlaced PNGs); such images must be decoded fully instead.
+var ErrStreamUnsupported = errors.New("stream: unsupported image")
+
+// streamStripPixels is the approximate number of pixels per strip passed to the callback of StreamPNG
+const streamStripPixels = 1 << 16
+
+// streamChunkSizes are the largest sizes of the chunks before the image data that StreamPNG reads into
+// memory. Other chunks, and larger ones of these types, are skipped without being read into memory,
+// so that a corrupt or malicious chunk length cannot cause a large allocation.
+var streamChunkSizes = map[string]int{
+	"IHDR": 13,
+	"PLTE": 3 * 256,
+	"tRNS": 256,
+	"iCCP": 16 << 20,
+	"eXIf": 1 << 20,
+	"IEND": 0,
+}
+
+// StreamPNG decodes a non-interlaced PNG image incrementally, calling `f` with consecutive horizontal
+// strips of rows, so that memory use does not depend on the image height. Strips are reused between
+// calls and must not be retained. Unless disabled by the options, colors are converted to sRGB using
+// the embedded ICC profile. The EXIF orientation is not applied to the strips, but is reflected in the
+// returned dimensions.
+func StreamPNG(r io.Reader, o DecodeOptions, f func(strip image.Image) error) (image.Config, error) {
+	d := &pngStream{r: bufio.NewReader(r)}
+	if err := d.readHeader(); err != nil {
+		return image.Config{}, err
+	}
+	config := image.Config{Width: d.width, Height: d.height}
+	if !o.IgnoreOrientation && d.orientation >= 5 {
+		config.Width, config.Height = config.Height, config.Width
+	}
+	var transform *iccTransform
+	if !o.IgnoreICC && len(d.icc) > 0 {
+		if p, err := parseICC(d.icc); err == nil {
+			if t := newICCTransform(p); !t.isIdentity() {
+				transform = t
+			}
+		}
+	}
+	z, err := zlib.NewReader(&d.idat)
+	if err != nil {
+		return config, err
+	}
+	defer z.Close()
+
+	rowSize := 1 + (d.width*d.bitsPerPixel()+7)/8
+	current, previous := make([]byte, rowSize), make([]byte, rowSize)
+	stripRows := streamStripPixels / d.width
+	if stripRows < 1 {
+		stripRows = 1
+	}
+	for y := 0; y < d.height; y += stripRows {
+		rows := stripRows
+		if y+rows > d.height {
+			rows = d.height - y
+		}
+		strip := d.newStrip(y, rows)
+		for ry := y; ry < y+rows; ry++ {
+			if _, err := io.ReadFull(z, current); err != nil {
+				return config, fmt.Errorf("png: reading row %d: %v", ry, err)
+			}
+			if err := unfilter(current, previous, (d.bitsPerPixel()+7)/8); err != nil {
+				return config, err
+			}
+			d.setRow(strip, ry, current[1:])
+			current, previous = previous, current
+		}
+		var out image.Image = strip
+		if transform != nil {
+			out = transform.apply(strip)
+		}
+		if err := f(out); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// pngStream holds the state of an incremental PNG decode
+type pngStream struct {
+	r             *bufio.Reader
+	width, height int
+	depth         int
+	colorType     byte
+	palette       color.Palette
+	transparent   []byte
+	icc           []byte
+	orientation   int
+	idat          idatReader
+	strip         image.Image
+}
+
+// readHeader reads the chunks up to the first IDAT chunk
+func (d *pngStream) readHeader() error {
+	var signature [8]byte
+	if _, err := io.ReadFull(d.r, signature[:]); err != nil {
+		return err
+	}
+	if string(signature[:]) != "\x89PNG\r\n\x1a\n" {
+		return errors.New("png: not a PNG file")
+	}
+	for {
+		length, typ, err := readChunkHeader(d.r)
+		if err != nil {
+			return err
+		}
+		if typ == "IDAT" {
+			if d.width == 0 {
+				return errors.New("png: missing IHDR chunk")
+			}
+			if d.colorType == 3 && len(d.palette) == 0 {
+				return errors.New("png: missing PLTE chunk")
+			}
+			d.idat = idatReader{r: d.r, remaining: length}
+			return nil
+		}
+		if size, ok := streamChunkSizes[typ]; !ok || length > size {
+			if _, err := io.CopyN(ioutil.Discard, d.r, int64(length)+4); err != nil { // data and CRC
+				return err
+			}
+			continue
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			return err
+		}
+		if _, err := d.r.Discard(4); err != nil { // CRC
+			return err
+		}
+		switch typ {
+		case "IHDR":
+			if err := d.parseIHDR(data); err != nil {
+				return err
+			}
+		case "PLTE":
+			d.palette = make(color.Palette, len(data)/3)
+			for j := range d.palette {
+				d.palette[j] = color.RGBA{R: data[3*j], G: data[3*j+1], B: data[3*j+2], A: 0xff}
+			}
+		case "tRNS":
+			d.transparent = data
+		case "iCCP":
+			if n := bytes.IndexByte(data, 0); n >= 0 && n+2 <= len(data) {
+				if r, err := zlib.NewReader(bytes.NewReader(data[n+2:])); err == nil {
+					d.icc, _ = ioutil.ReadAll(r)
+				}
+			}
+		case "eXIf":
+			d.orientation = exifOrientation(data)
+		case "IEND":
+			return errors.New("png: no image data")
+		}
+	}
+}
+
+func (d *pngStream) parseIHDR(data []byte) error {
+	if len(data) != 13 {
+		return errors.New("png: invalid IHDR chunk")
+	}
+	d.width = int(binary.BigEndian.Uint32(data[0:4]))
+	d.height = int(binary.BigEndian.Uint32(data[4:8]))
+	d.depth = int(data[8])
+	d.colorType = data[9]
+	if d.width <= 0 || d.height <= 0 {
+		return errors.New("png: invalid dimensions")
+	}
+	if data[12] != 0 {
+		return ErrStreamUnsupported
+	}
+	valid := map[byte][]int{0: {1, 2, 4, 8, 16}, 2: {8, 16}, 3: {1, 2, 4, 8}, 4: {8, 16}, 6: {8, 16}}
+	for _, depth := range valid[d.colorType] {
+		if depth == d.depth {
+			return nil
+		}
+	}
+	return fmt.Errorf("png: unsupported color type %d with bit depth %d", d.colorType, d.depth)
+}
+
+func (d *pngStream) bitsPerPixel() int {
+	channels := map[byte]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[d.colorType]
+	return channels * d.depth
+}
+
+// newStrip returns an image for `rows` rows starting at `y`, reusing the previous strip's pixels
+func (d *pngStream) newStrip(y, rows int) image.Image {
+	rect := image.Rect(0, y, d.width, y+rows)
+	switch s := d.strip.(type) {
+	case *image.Paletted:
+		if len(s.Pix) >= rows*s.Stride {
+			s.Pix, s.Rect = s.Pix[:rows*s.Stride], rect
+			return s
+		}
+	case *image.NRGBA:
+		if len(s.Pix) >= rows*s.Stride {
+			s.Pix, s.Rect = s.Pix[:rows*s.Stride], rect
+			return s
+		}
+	case *image.NRGBA64:
+		if len(s.Pix) >= rows*s.Stride {
+			s.Pix, s.Rect = s.Pix[:rows*s.Stride], rect
+			return s
+		}
+	}
+	switch {
+	case d.colorType == 3:
+		p := make(color.Palette, len(d.palette))
+		copy(p, d.palette)
+		for j, a := range d.transparent {
+			if j < len(p) {
+				c := p[j].(color.RGBA)
+				p[j] = color.NRGBA{R: c.R, G: c.G, B: c.B, A: a}
+			}
+		}
+		d.strip = image.NewPaletted(rect, p)
+	case d.depth == 16:
+		d.strip = image.NewNRGBA64(rect)
+	default:
+		d.strip = image.NewNRGBA(rect)
+	}
+	return d.strip
+}
+
+// setRow converts an unfiltered row of samples into row `y` of the strip
+func (d *pngStream) setRow(strip image.Image, y int, row []byte) {
+	switch s := strip.(type) {
+	case *image.Paletted:
+		pix := s.Pix[s.PixOffset(0, y):]
+		perByte := 8 / d.depth
+		mask := byte(1<<uint(d.depth) - 1)
+		for x := 0; x < d.width; x++ {
+			shift := uint(8 - d.depth*(x%perByte+1))
+			pix[x] = row[x/perByte] >> shift & mask
+		}
+	case *image.NRGBA64:
+		pix := s.Pix[s.PixOffset(0, y):]
+		for x := 0; x < d.width; x++ {
+			var c [4]uint16
+			switch d.colorType {
+			case 0:
+				v := binary.BigEndian.Uint16(row[2*x:])
+				c = [4]uint16{v, v, v, 0xffff}
+				if len(d.transparent) >= 2 && v == binary.BigEndian.Uint16(d.transparent) {
+					c[3] = 0
+				}
+			case 2:
+				q := row[6*x:]
+				c = [4]uint16{binary.BigEndian.Uint16(q), binary.BigEndian.Uint16(q[2:]), binary.BigEndian.Uint16(q[4:]), 0xffff}
+				if len(d.transparent) >= 6 && bytes.Equal(q[:6], d.transparent[:6]) {
+					c[3] = 0
+				}
+			case 4:
+				v := binary.BigEndian.Uint16(row[4*x:])
+				c = [4]uint16{v, v, v, binary.BigEndian.Uint16(row[4*x+2:])}
+			case 6:
+				q := row[8*x:]
+				c = [4]uint16{binary.BigEndian.Uint16(q), binary.BigEndian.Uint16(q[2:]), binary.BigEndian.Uint16(q[4:]), binary.BigEndian.Uint16(q[6:])}
+			}
+			for j, v := range c {
+				binary.BigEndian.PutUint16(pix[8*x+2*j:], v)
+			}
+		}
+	case *image.NRGBA:
+		pix := s.Pix[s.PixOffset(0, y):]
+		for x := 0; x < d.width; x++ {
+			var c [4]byte
+			switch d.colorType {
+			case 0:
+				perByte := 8 / d.depth
+				shift := uint(8 - d.depth*(x%perByte+1))
+				mask := byte(1<<uint(d.depth) - 1)
+				raw := row[x/perByte] >> shift & mask
+				v := raw * (0xff / mask)
+				c = [4]byte{v, v, v, 0xff}
+				if len(d.transparent) >= 2 && uint16(raw) == binary.BigEndian.Uint16(d.transparent) {
+					c[3] = 0
+				}
+			case 2:
+				q := row[3*x:]
+				c = [4]byte{q[0], q[1], q[2], 0xff}
+				if len(d.transparent) >= 6 && q[0] == d.transparent[1] && q[1] == d.transparent[3] && q[2] == d.transparent[5] {
+					c[3] = 0
+				}
+			case 4:
+				c = [4]byte{row[2*x], row[2*x], row[2*x], row[2*x+1]}
+			case 6:
+				copy(c[:], row[4*x:])
+			}
+			copy(pix[4*x:], c[:])
+		}
+	}
+}
+
+// unfilter reverses the PNG filter of a row in place, given the previous (unfiltered) row
+func unfilter(current, previous []byte, bpp int) error {
+	filter, cdat, pdat := current[0], current[1:], previous[1:]
+	switch filter {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += cdat[i-bpp]
+		}
+	case 2:
+		for i := range cdat {
+			cdat[i] += pdat[i]
+		}
+	case 3:
+		for i := range cdat {
+			var left byte
+			if i >= bpp {
+				left = cdat[i-bpp]
+			}
+			cdat[i] += byte((int(left) + int(pdat[i])) / 2)
+		}
+	case 4:
+		for i := range cdat {
+			var a, c int
+			if i >= bpp {
+				a, c = int(cdat[i-bpp]), int(pdat[i-bpp])
+			}
+			cdat[i] += paeth(a, int(pdat[i]), c)
+		}
+	default:
+		return fmt.Errorf("png: invalid filter type %d", filter)
+	}
+	return nil
+}
+
+func paeth(a, b, c int) byte {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return byte(a)
+	}
+	if pb <= pc {
+		return byte(b)
+	}
+	return byte(c)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func readChunkHeader(r io.Reader) (int, string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", err
+	}
+	return int(binary.BigEndian.Uint32(header[:4])), string(header[4:]), nil
+}
+
+// idatReader reads the concatenated contents of consecutive IDAT chunks
+type idatReader struct {
+	r         *bufio.Reader
+	remaining int
+}
+
+func (i *idatReader) Read(p []byte) (int, error) {
+	for i.remaining == 0 {
+		if _, err := i.r.Discard(4); err != nil { // CRC
+			return 0, err
+		}
+		length, typ, err := readChunkHeader(i.r)
+		if err != nil {
+			return 0, err
+		}
+		if typ != "IDAT" {
+			return 0, io.ErrUnexpectedEOF
+		}
+		i.remaining = length
+	}
+	if len(p) > i.remaining {
+		p = p[:i.remaining]
+	}
+	n, err := i.r.Read(p)
+	i.remaining -= n
+	return n, err
+}
@@ -0,0 +1,125 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// streamImages returns images that encode to PNGs of every color type, with several IDAT chunks
+// and strips for the larger ones
+func streamImages() map[string]image.Image {
+	rng := rand.New(rand.NewSource(0))
+	const w, h = 300, 250
+	rect := image.Rect(0, 0, w, h)
+	nrgba, rgba, nrgba64 := image.NewNRGBA(rect), image.NewRGBA(rect), image.NewNRGBA64(rect)
+	gray, gray16 := image.NewGray(rect), image.NewGray16(rect)
+	paletted := image.NewPaletted(rect, color.Palette{
+		color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 0, 0}, color.NRGBA{0, 0, 255, 128}, color.NRGBA{255, 255, 255, 255},
+	})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := rng.Uint64()
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(v), uint8(v >> 8), uint8(v >> 16), uint8(v >> 24)})
+			rgba.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(v), 255})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(v), uint16(v >> 16), uint16(v >> 32), uint16(v >> 48)})
+			gray.SetGray(x, y, color.Gray{uint8(v)})
+			gray16.SetGray16(x, y, color.Gray16{uint16(v)})
+			paletted.SetColorIndex(x, y, uint8(v%4))
+		}
+	}
+	return map[string]image.Image{
+		"rgba":     nrgba,
+		"rgb":      rgba,
+		"rgba16":   nrgba64,
+		"gray":     gray,
+		"gray16":   gray16,
+		"paletted": paletted,
+	}
+}
+
+func encodePNG(t *testing.T, i image.Image) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := png.Encode(&b, i); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// checkStream checks that streaming `data` yields the same pixels as decoding it fully
+func checkStream(t *testing.T, data []byte) {
+	t.Helper()
+	full, _, err := DecodeWith(data, DecodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, strips := 0, 0
+	config, err := StreamPNG(bytes.NewReader(data), DecodeOptions{}, func(strip image.Image) error {
+		b := strip.Bounds()
+		if b.Min.Y != rows {
+			t.Fatalf("strip starts at row %d, want %d", b.Min.Y, rows)
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, b, a := strip.At(x, y).RGBA()
+				wr, wg, wb, wa := full.At(x, y).RGBA()
+				if r != wr || g != wg || b != wb || a != wa {
+					t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, [4]uint32{r, g, b, a}, [4]uint32{wr, wg, wb, wa})
+				}
+			}
+		}
+		rows += b.Dy()
+		strips++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != full.Bounds().Dx() || config.Height != full.Bounds().Dy() || rows != config.Height {
+		t.Errorf("streamed %dx%d in %d rows, want %dx%d", config.Width, config.Height, rows, full.Bounds().Dx(), full.Bounds().Dy())
+	}
+	if strips < 2 {
+		t.Errorf("streamed %d strips, want several", strips)
+	}
+}
+
+func TestStreamPNGMatchesDecode(t *testing.T) {
+	for name, i := range streamImages() {
+		t.Run(name, func(t *testing.T) {
+			checkStream(t, encodePNG(t, i))
+		})
+	}
+}
+
+func TestStreamPNGSkipsChunks(t *testing.T) {
+	data := encodePNG(t, streamImages()["rgb"])
+	// unknown chunks and oversized known ones are skipped
+	data = insertChunk(data, "zzZz", make([]byte, 100000))
+	data = insertChunk(data, "eXIf", make([]byte, 2<<20))
+	checkStream(t, data)
+}
+
+func TestStreamPNGChunkLength(t *testing.T) {
+	data := encodePNG(t, streamImages()["gray"])
+	for _, typ := range []string{"zzZz", "iCCP", "eXIf", "tEXt"} {
+		// a truncated file whose chunk claims to be nearly 2 GB long
+		corrupt := insertChunk(data, typ, nil)[:8+8+13+4+8]
+		binary.BigEndian.PutUint32(corrupt[8+8+13+4:], 0x7fffffff)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := StreamPNG(bytes.NewReader(corrupt), DecodeOptions{}, func(image.Image) error { return nil })
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("%s: StreamPNG of truncated file succeeded", typ)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("%s: StreamPNG allocated %d bytes for a corrupt chunk length", typ, allocated)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package input
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Symlink policies for Walk
+const (
+	SymlinksSkip   = "skip"
+	SymlinksFiles  = "files"
+	SymlinksFollow = "follow"
+)
+
+// SymlinkPolicies lists the valid values of WalkOptions.Symlinks
+var SymlinkPolicies = []string{SymlinksSkip, SymlinksFiles, SymlinksFollow}
+
+// WalkOptions configure Walk
+type WalkOptions struct {
+	// Include and Exclude are matched against slash-separated paths relative to the walked root.
+	// If Include is non-empty, only files matching one of its globs are visited.
+	// Directories matching an Exclude glob are not descended into.
+	Include []glob.Glob
+	Exclude []glob.Glob
+	// Symlinks is one of SymlinkPolicies: skip all symlinks, follow symlinks to files only, or follow all symlinks.
+	// The zero value follows symlinks to files only.
+	Symlinks string
+	// Archives, if non-nil, is used to expand zip and tar archives into the images they contain.
+	// Include and Exclude are then also matched against entry paths inside archives.
+	Archives *Archives
+	// Select, if non-nil, is called with the path of each file and archive entry (`archive!/entry`)
+	// that passes Include and Exclude, before it is read. Files and entries it rejects are skipped.
+	Select func(path string) bool
+	// MaxDepth limits the number of directory levels visited, counting the root as 1; 0 means no limit
+	MaxDepth int
+}
+
+func matchAny(globs []glob.Glob, path string) bool {
+	for _, g := range globs {
+		if g.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls `fn` for each image file below `root` in lexical order.
+// Files are recognized as images by their magic bytes, not by their extension.
+func Walk(root string, o WalkOptions, fn func(path string)) error {
+	w := walker{WalkOptions: o, root: root, fn: fn, visited: make(map[string]bool)}
+	return w.walk(root, 0)
+}
+
+type walker struct {
+	WalkOptions
+	root    string
+	fn      func(path string)
+	visited map[string]bool
+}
+
+func (w *walker) walk(dir string, depth int) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if abs, err := filepath.Abs(real); err == nil {
+			real = abs
+		}
+		if w.visited[real] {
+			return nil
+		}
+		w.visited[real] = true
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, e := range entries {
+		path := joinPath(dir, e.Name())
+		rel, _ := filepath.Rel(w.root, path)
+		rel = filepath.ToSlash(rel)
+		mode := e.Mode()
+		if mode&os.ModeSymlink != 0 {
+			if w.Symlinks == SymlinksSkip {
+				continue
+			}
+			target, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if target.IsDir() && w.Symlinks != SymlinksFollow {
+				continue
+			}
+			mode = target.Mode()
+		}
+		if matchAny(w.Exclude, rel) {
+			continue
+		}
+		if mode.IsDir() {
+			if w.MaxDepth > 0 && depth+1 >= w.MaxDepth {
+				continue
+			}
+			if err := w.walk(path, depth+1); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		if w.Archives != nil && IsArchive(path) {
+			if err := w.Archives.Expand(path, w.entryFilter(path), w.fn); err != nil {
+				errs = append(errs, err.Error())
+			}
+			continue
+		}
+		if len(w.Include) > 0 && !matchAny(w.Include, rel) {
+			continue
+		}
+		if w.Select != nil && !w.Select(path) {
+			continue
+		}
+		if _, ok := Sniff(path); !ok {
+			continue
+		}
+		w.fn(path)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// joinPath joins a directory and a name without cleaning the directory, so that
+// walked paths keep the prefix given by the user (e.g. `./`) and still match globs written against it
+func joinPath(dir, name string) string {
+	switch {
+	case dir == ".":
+		return name
+	case strings.HasSuffix(dir, string(filepath.Separator)):
+		return dir + name
+	}
+	return dir + string(filepath.Separator) + name
+}
+
+// entryFilter returns the filter for the entries of the archive at `archivePath`
+func (o *WalkOptions) entryFilter(archivePath string) func(entryPath string) bool {
+	return func(entryPath string) bool {
+		switch {
+		case matchAny(o.Exclude, entryPath):
+			return false
+		case len(o.Include) > 0 && !matchAny(o.Include, entryPath):
+			return false
+		case o.Select != nil:
+			return o.Select(archivePath + ArchiveSeparator + entryPath)
+		}
+		return true
+	}
+}
+
+// Expand calls `fn` for `path`, or for each image file below it if it is a directory or an archive
+func Expand(path string, o WalkOptions, fn func(path string)) error {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return Walk(path, o, fn)
+	}
+	if o.Archives != nil && IsArchive(path) {
+		return o.Archives.Expand(path, o.entryFilter(path), fn)
+	}
+	fn(path)
+	return nil
+}
+
+// GlobBase returns the longest leading directory of a glob pattern that contains no glob syntax
+func GlobBase(pattern string) string {
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	var base []string
+	for _, p := range parts[:len(parts)-1] {
+		if strings.ContainsAny(p, "*?[{\\") {
+			break
+		}
+		base = append(base, p)
+	}
+	if len(base) == 0 {
+		return "."
+	}
+	if len(base) == 1 && base[0] == "" {
+		return "/"
+	}
+	return filepath.FromSlash(strings.Join(base, "/"))
+}
+
+// GlobDepth returns the number of directory levels below GlobBase(pattern) that the pattern can match,
+// counting the base as 1 like WalkOptions.MaxDepth, or 0 if the pattern contains `**` and matches at any depth
+func GlobDepth(pattern string) int {
+	if strings.Contains(pattern, "**") {
+		return 0
+	}
+	parts := strings.Split(filepath.ToSlash(pattern), "/")
+	depth := len(parts)
+	for _, p := range parts[:len(parts)-1] {
+		if strings.ContainsAny(p, "*?[{\\") {
+			break
+		}
+		depth--
+	}
+	return depth
+}
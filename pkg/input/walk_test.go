@@ -0,0 +1,153 @@
+package input
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gobwas/glob"
+)
+
+// walkTree creates a directory tree of images and other files, with symlinks to a file,
+// to a directory, and back to a parent directory, and returns its root:
+//
+//	a.png, fake.png (text), misnamed.dat (PNG), notes.txt
+//	sub/b.png, sub/deep/c.png, sub/deep/loop -> sub, sub/thumbnails/t.png
+//	link.png -> sub/b.png, linkdir -> sub/deep
+func walkTree(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	png := encodePNG(t, image.NewGray(image.Rect(0, 0, 2, 2)))
+	files := map[string][]byte{
+		"a.png":                png,
+		"fake.png":             []byte("not an image"),
+		"misnamed.dat":         png,
+		"notes.txt":            []byte("notes"),
+		"sub/b.png":            png,
+		"sub/deep/c.png":       png,
+		"sub/thumbnails/t.png": png,
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"link.png":      filepath.Join("sub", "b.png"),
+		"linkdir":       filepath.Join("sub", "deep"),
+		"sub/deep/loop": "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	return root
+}
+
+// walkPaths walks `root` and returns the visited paths relative to it, slash-separated
+func walkPaths(t *testing.T, root string, o WalkOptions) []string {
+	t.Helper()
+	var paths []string
+	err := Walk(root, o, func(path string) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func globs(patterns ...string) []glob.Glob {
+	out := make([]glob.Glob, len(patterns))
+	for i, p := range patterns {
+		out[i] = glob.MustCompile(p, '/')
+	}
+	return out
+}
+
+func TestWalk(t *testing.T) {
+	root := walkTree(t)
+	all := []string{"a.png", "misnamed.dat", "sub/b.png", "sub/deep/c.png", "sub/thumbnails/t.png"}
+	tests := []struct {
+		name string
+		o    WalkOptions
+		want []string
+	}{
+		// files are recognized by their magic bytes, so misnamed.dat is visited and fake.png is not
+		{"default follows symlinks to files", WalkOptions{}, []string{"a.png", "link.png", "misnamed.dat", "sub/b.png", "sub/deep/c.png", "sub/thumbnails/t.png"}},
+		{"include", WalkOptions{Symlinks: SymlinksSkip, Include: globs("**/*.png")}, []string{"sub/b.png", "sub/deep/c.png", "sub/thumbnails/t.png"}},
+		{"include at any depth", WalkOptions{Symlinks: SymlinksSkip, Include: globs("*.png", "**/*.png")}, []string{"a.png", "sub/b.png", "sub/deep/c.png", "sub/thumbnails/t.png"}},
+		{"exclude directory", WalkOptions{Symlinks: SymlinksSkip, Exclude: globs("**/thumbnails")}, []string{"a.png", "misnamed.dat", "sub/b.png", "sub/deep/c.png"}},
+		{"exclude files", WalkOptions{Symlinks: SymlinksSkip, Exclude: globs("**.dat", "sub/**/*.png")}, []string{"a.png", "sub/b.png"}},
+		{"max depth 1", WalkOptions{Symlinks: SymlinksSkip, MaxDepth: 1}, []string{"a.png", "misnamed.dat"}},
+		{"max depth 2", WalkOptions{Symlinks: SymlinksSkip, MaxDepth: 2}, []string{"a.png", "misnamed.dat", "sub/b.png"}},
+		{"max depth 3", WalkOptions{Symlinks: SymlinksSkip, MaxDepth: 3}, all},
+		{"symlinks skipped", WalkOptions{Symlinks: SymlinksSkip}, all},
+		{"symlinks to files", WalkOptions{Symlinks: SymlinksFiles}, []string{"a.png", "link.png", "misnamed.dat", "sub/b.png", "sub/deep/c.png", "sub/thumbnails/t.png"}},
+		{"select", WalkOptions{Symlinks: SymlinksSkip, Select: func(path string) bool { return filepath.Base(path) != "a.png" }}, all[1:]},
+	}
+	for _, tt := range tests {
+		if got := walkPaths(t, root, tt.o); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: visited %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestWalkFollowSymlinks checks that following symlinks terminates despite the loop,
+// and visits each directory once
+func TestWalkFollowSymlinks(t *testing.T) {
+	root := walkTree(t)
+	paths := walkPaths(t, root, WalkOptions{Symlinks: SymlinksFollow})
+	var targets []string
+	for _, path := range paths {
+		real, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(root, real)
+		targets = append(targets, filepath.ToSlash(rel))
+	}
+	sort.Strings(targets)
+	// link.png is a file symlink, so sub/b.png is visited twice, through link.png and through a directory
+	want := []string{"a.png", "misnamed.dat", "sub/b.png", "sub/b.png", "sub/deep/c.png", "sub/thumbnails/t.png"}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("visited %v (%v), want %v", paths, targets, want)
+	}
+}
+
+func TestGlobBaseDepth(t *testing.T) {
+	tests := []struct {
+		pattern string
+		base    string
+		depth   int
+	}{
+		{"*.jpg", ".", 1},
+		{"photos/*.jpg", "photos", 1},
+		{"photos/2020/*/*.jpg", filepath.Join("photos", "2020"), 2},
+		{"photos/*/raw/*.jpg", "photos", 3},
+		{"/data/*.png", filepath.FromSlash("/data"), 1},
+		{"photos/**/*.jpg", "photos", 0},
+		{"**.jpg", ".", 0},
+	}
+	for _, tt := range tests {
+		if got := GlobBase(tt.pattern); got != tt.base {
+			t.Errorf("GlobBase(%q) = %q, want %q", tt.pattern, got, tt.base)
+		}
+		if got := GlobDepth(tt.pattern); got != tt.depth {
+			t.Errorf("GlobDepth(%q) = %d, want %d", tt.pattern, got, tt.depth)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package logging configures the structured logs of the commands and classifies per-file errors.
+//
+// Log records are written in one of three formats: human-readable text (the default),
+// logfmt, or JSON lines. Every record about a single file has a `path` attribute.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log formats
+const (
+	FormatText   = "text"
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+// Formats are the supported log formats
+var Formats = []string{FormatText, FormatLogfmt, FormatJSON}
+
+// New returns a logger writing records of at least the given level to `w` in the given format
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	o := &slog.HandlerOptions{Level: level}
+	switch format {
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, o))
+	case FormatLogfmt:
+		return slog.New(slog.NewTextHandler(w, o))
+	}
+	return slog.New(&textHandler{mu: &sync.Mutex{}, w: w, level: level})
+}
+
+// textHandler writes records as single human-readable lines:
+//
+//	2006/01/02 15:04:05 [LEVEL] [path] message: value
+//	2006/01/02 15:04:05 [LEVEL] [path] message key=value key=value
+//
+// The level is omitted for INFO records. Groups are flattened.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *h
+	out.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &out
+}
+
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := append([]slog.Attr(nil), h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	var b strings.Builder
+	if !r.Time.IsZero() {
+		b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	}
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String())
+		b.WriteByte(' ')
+	}
+	rest := attrs[:0]
+	for _, a := range attrs {
+		if a.Key == "path" {
+			b.WriteString(textValue(a.Value))
+			b.WriteByte(' ')
+			continue
+		}
+		rest = append(rest, a)
+	}
+	b.WriteString(r.Message)
+	if len(rest) == 1 {
+		b.WriteString(": ")
+		b.WriteString(textValue(rest[0].Value))
+	} else {
+		for _, a := range rest {
+			b.WriteByte(' ')
+			b.WriteString(a.Key)
+			b.WriteByte('=')
+			b.WriteString(quote(textValue(a.Value)))
+		}
+	}
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// textValue formats a value; values with their own log representation use their String method
+func textValue(v slog.Value) string {
+	if v.Kind() == slog.KindLogValuer {
+		return fmt.Sprint(v.Any())
+	}
+	return v.Resolve().String()
+}
+
+// quote quotes values that would otherwise be ambiguous in a key=value list
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Stages of processing a file, as recorded in error records
+const (
+	StageRead     = "read"
+	StageDecode   = "decode"
+	StageExtract  = "extract"
+	StageApply    = "apply"
+	StageCluster  = "cluster"
+	StageLoad     = "load"
+	StageTemplate = "template"
+	StageWrite    = "write"
+	StageShell    = "shell"
+)
+
+// StageError is an error that occurred at a stage of processing a file
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string { return e.Err.Error() }
+
+func (e *StageError) Unwrap() error { return e.Err }
+
+// WithStage annotates an error with the stage it occurred at, unless it already is.
+// It returns nil for a nil error.
+func WithStage(stage string, err error) error {
+	var e *StageError
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// StageOf returns the stage an error occurred at, or `stage` if it is not annotated
+func StageOf(err error, stage string) string {
+	var e *StageError
+	if errors.As(err, &e) {
+		return e.Stage
+	}
+	return stage
+}
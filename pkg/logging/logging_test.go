@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// summary is a value with its own log representation
+type summary struct{}
+
+func (summary) String() string { return "3 images in 1s" }
+
+func (summary) LogValue() slog.Value { return slog.IntValue(3) }
+
+func TestTextHandler(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+		msg   string
+		attrs []interface{}
+		want  string
+	}{
+		{"info level omitted", slog.LevelInfo, "done", nil, "done\n"},
+		{"other levels", slog.LevelWarn, "caching failed", nil, "WARN caching failed\n"},
+		{"single value", slog.LevelInfo, "wrote", []interface{}{"output", "out dir/a.png"}, "wrote: out dir/a.png\n"},
+		{"path first", slog.LevelError, "failed", []interface{}{"stage", "read", "path", "a.png", "error", "missing"}, "ERROR a.png failed stage=read error=missing\n"},
+		{"path and single value", slog.LevelInfo, "palette", []interface{}{"path", "a.png", "palette", "#000000"}, "a.png palette: #000000\n"},
+		{"quoted values", slog.LevelInfo, "loaded", []interface{}{"format", "png", "error", "no such file", "empty", "", "eq", "a=b"}, `loaded format=png error="no such file" empty="" eq="a=b"` + "\n"},
+		{"log valuer", slog.LevelInfo, "done", []interface{}{"stats", summary{}}, "done: 3 images in 1s\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		h := New(&b, FormatText, slog.LevelInfo).Handler()
+		r := slog.NewRecord(time.Time{}, tt.level, tt.msg, 0)
+		r.Add(tt.attrs...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != tt.want {
+			t.Errorf("%s: wrote %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTextHandlerLogger(t *testing.T) {
+	var b bytes.Buffer
+	logger := New(&b, FormatText, slog.LevelInfo)
+	logger.Debug("hidden")
+	logger.With("path", "a.png").Info("loaded", "width", 2, "height", 3)
+	line := b.String()
+	// records have a time prefix
+	if _, err := time.Parse("2006/01/02 15:04:05", line[:len("2006/01/02 15:04:05")]); err != nil {
+		t.Errorf("line %q does not start with the time: %v", line, err)
+	}
+	if want := " a.png loaded width=2 height=3\n"; !strings.HasSuffix(line, want) || strings.Count(line, "\n") != 1 {
+		t.Errorf("wrote %q, want a time and %q", line, want)
+	}
+}
+
+func TestStage(t *testing.T) {
+	err := errors.New("boom")
+	staged := WithStage(StageDecode, err)
+	if got := StageOf(staged, StageRead); got != StageDecode {
+		t.Errorf("StageOf(WithStage(%q, err)) = %q", StageDecode, got)
+	}
+	if got := StageOf(err, StageRead); got != StageRead {
+		t.Errorf("StageOf of an error without a stage = %q, want %q", got, StageRead)
+	}
+	// the first stage is kept
+	if got := StageOf(WithStage(StageWrite, staged), ""); got != StageDecode {
+		t.Errorf("stage after annotating twice = %q, want %q", got, StageDecode)
+	}
+	if !errors.Is(staged, err) || staged.Error() != err.Error() {
+		t.Errorf("WithStage(err) = %v, want an error wrapping %v", staged, err)
+	}
+	if WithStage(StageRead, nil) != nil {
+		t.Error("WithStage(nil) is not nil")
+	}
+}
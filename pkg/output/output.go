@@ -0,0 +1,180 @@
+// Package output writes the commands' output files.
+//
+// Each file is written to a temporary file next to it and renamed into place once complete,
+// so that an output is either missing, unchanged, or completely written, never partially
+// written or mixed with a previous version. Missing parent directories are created.
+package output
+
+import (
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+)
+
+// Policies for outputs that already exist
+const (
+	// Overwrite replaces existing outputs
+	Overwrite = "overwrite"
+	// SkipExisting leaves existing outputs as they are
+	SkipExisting = "skip-existing"
+	// NoClobber leaves existing outputs as they are and fails with an error wrapping os.ErrExist
+	NoClobber = "no-clobber"
+)
+
+// Policies are the supported policies for existing outputs
+var Policies = []string{Overwrite, SkipExisting, NoClobber}
+
+// Writer writes output files. The zero value overwrites existing outputs.
+type Writer struct {
+	// Policy is one of Policies (default Overwrite)
+	Policy string
+	// Perm is the permission of new files before the umask (default 0666, as for os.Create).
+	// Replaced files keep their permissions.
+	Perm os.FileMode
+}
+
+// Check returns whether `path` should be written: false if it exists and the policy is SkipExisting.
+// Under NoClobber, it returns an error if `path` exists.
+func (w Writer) Check(path string) (bool, error) {
+	if w.Policy != SkipExisting && w.Policy != NoClobber {
+		return true, nil
+	}
+	_, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	case w.Policy == NoClobber:
+		return false, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+	}
+	return false, nil
+}
+
+// Write writes the output `path` like WriteFile and logs whether it was written or skipped.
+// Errors are returned with the write stage (see logging.WithStage).
+func (w Writer) Write(path string, write func(w io.Writer) error) error {
+	written, err := w.WriteFile(path, write)
+	switch {
+	case err != nil:
+		return logging.WithStage(logging.StageWrite, err)
+	case written:
+		slog.Info("wrote", "output", path)
+	default:
+		slog.Info("skipped existing", "output", path)
+	}
+	return nil
+}
+
+// WriteFile writes the output `path` using `write`, and returns whether it was written
+// (see Check). If `write` or any other step fails, the output is left unchanged.
+//
+// Outputs that exist but are not regular files, such as /dev/stdout or named pipes, are
+// written to directly. Errors are *os.PathError values for `path`.
+func (w Writer) WriteFile(path string, write func(w io.Writer) error) (bool, error) {
+	if ok, err := w.Check(path); !ok {
+		return false, err
+	}
+	perm := w.Perm
+	if perm == 0 {
+		perm = 0666
+	}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.Mode().IsRegular():
+		return true, writeDirect(path, write)
+	case err == nil:
+		perm = info.Mode().Perm()
+		// replace the file a symlink points to rather than the symlink
+		if resolved, err := filepath.EvalSymlinks(path); err == nil {
+			path = resolved
+		}
+	case !os.IsNotExist(err):
+		return false, pathError("stat", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, pathError("mkdir", path, err)
+	}
+	f, err := createTemp(dir, filepath.Base(path), perm)
+	if err != nil {
+		return false, pathError("create", path, err)
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return false, pathError("write", path, err)
+	}
+	if info != nil {
+		// the umask may have narrowed the permissions of the temporary file
+		f.Chmod(perm)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return false, pathError("write", path, err)
+	}
+	if err := rename(tmp, path, w.Policy == NoClobber); err != nil {
+		os.Remove(tmp)
+		return false, pathError("rename", path, err)
+	}
+	return true, nil
+}
+
+// rename moves the temporary file `tmp` to `path`. With noClobber, it fails if `path` has been
+// created in the meantime, if the file system supports hard links.
+func rename(tmp, path string, noClobber bool) error {
+	if !noClobber {
+		return os.Rename(tmp, path)
+	}
+	err := os.Link(tmp, path)
+	if err == nil || os.IsExist(err) {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// createTemp creates a new hidden file in `dir` whose name starts with `name`
+func createTemp(dir, name string, perm os.FileMode) (*os.File, error) {
+	for i := 0; ; i++ {
+		tmp := filepath.Join(dir, "."+name+".tmp-"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return f, err
+	}
+}
+
+func writeDirect(path string, write func(w io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return pathError("open", path, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return pathError("write", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return pathError("write", path, err)
+	}
+	return nil
+}
+
+// pathError returns `err` as an error about `path`, replacing the temporary file's name
+func pathError(op, path string, err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,118 @@
+package output
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+)
+
+func writeString(s string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+// checkFile checks the content of `path`, and that no temporary files are left next to it
+func checkFile(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("%s contains %q, want %q", path, data, want)
+	}
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files in %s, want 1", len(entries), filepath.Dir(path))
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "b", "out.txt")
+	written, err := Writer{}.WriteFile(path, writeString("first"))
+	if err != nil || !written {
+		t.Fatalf("WriteFile = %v, %v; want true, nil", written, err)
+	}
+	checkFile(t, path, "first")
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Writer{Policy: Overwrite}).WriteFile(path, writeString("second")); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "second")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("replaced file has permissions %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// a failed write leaves the output unchanged
+	failure := errors.New("failure")
+	_, err = Writer{}.WriteFile(path, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("WriteFile error = %v, want %v", err, failure)
+	}
+	if e, ok := err.(*os.PathError); !ok || e.Path != path {
+		t.Errorf("WriteFile error = %#v, want *os.PathError for %s", err, path)
+	}
+	checkFile(t, path, "second")
+}
+
+func TestWriteFilePolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	if err := ioutil.WriteFile(path, []byte("existing"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	written, err := Writer{Policy: SkipExisting}.WriteFile(path, writeString("new"))
+	if err != nil || written {
+		t.Errorf("SkipExisting: WriteFile = %v, %v; want false, nil", written, err)
+	}
+	checkFile(t, path, "existing")
+	written, err = Writer{Policy: NoClobber}.WriteFile(path, writeString("new"))
+	if !errors.Is(err, os.ErrExist) || written {
+		t.Errorf("NoClobber: WriteFile = %v, %v; want false, an error wrapping os.ErrExist", written, err)
+	}
+	checkFile(t, path, "existing")
+
+	other := filepath.Join(filepath.Dir(path), "other", "out.txt")
+	for _, policy := range Policies {
+		os.RemoveAll(filepath.Dir(other))
+		written, err := Writer{Policy: policy}.WriteFile(other, writeString(policy))
+		if err != nil || !written {
+			t.Errorf("%s: WriteFile of a new file = %v, %v; want true, nil", policy, written, err)
+		}
+		checkFile(t, other, policy)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	if err := (Writer{}).Write(path, writeString("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Writer{Policy: SkipExisting}).Write(path, writeString("second")); err != nil {
+		t.Errorf("SkipExisting: Write = %v, want nil", err)
+	}
+	checkFile(t, path, "first")
+	err := Writer{Policy: NoClobber}.Write(path, writeString("second"))
+	if !errors.Is(err, os.ErrExist) || logging.StageOf(err, "") != logging.StageWrite {
+		t.Errorf("NoClobber: Write = %v, want an error wrapping os.ErrExist at stage %q", err, logging.StageWrite)
+	}
+	checkFile(t, path, "first")
+}
@@ -0,0 +1,162 @@
+package palette
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Dither is a dithering method used when applying a palette to an image
+type Dither int
+
+const (
+	// DitherNone maps each pixel to its nearest palette color
+	DitherNone Dither = iota
+	// DitherFloydSteinberg diffuses the quantization error to neighbouring pixels
+	DitherFloydSteinberg
+	// DitherOrdered perturbs pixels using an 8x8 Bayer threshold matrix
+	DitherOrdered
+)
+
+// Dithers lists the names of all dithering methods
+var Dithers = []string{"none", "floyd-steinberg", "ordered"}
+
+func (d Dither) String() string {
+	if int(d) < len(Dithers) {
+		return Dithers[d]
+	}
+	return fmt.Sprintf("Dither(%d)", int(d))
+}
+
+// ParseDither returns the dithering method with the given name
+func ParseDither(name string) (Dither, error) {
+	for i, n := range Dithers {
+		if strings.EqualFold(n, name) {
+			return Dither(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown dithering method %q", name)
+}
+
+var bayer8 = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+type nearestColor struct {
+	space  ColorSpace
+	points [][3]float64
+	cache  map[uint32]uint8
+}
+
+func newNearestColor(p []color.RGBA, s ColorSpace) *nearestColor {
+	n := &nearestColor{
+		space:  s,
+		points: make([][3]float64, len(p)),
+		cache:  make(map[uint32]uint8),
+	}
+	for i, c := range p {
+		n.points[i] = s.Point(c)
+	}
+	return n
+}
+
+func (n *nearestColor) index(c color.RGBA) uint8 {
+	key := uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+	if i, ok := n.cache[key]; ok {
+		return i
+	}
+	q := n.space.Point(c)
+	best, bestDistance := 0, math.Inf(1)
+	for i, p := range n.points {
+		d0, d1, d2 := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+		if d := d0*d0 + d1*d1 + d2*d2; d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	n.cache[key] = uint8(best)
+	return uint8(best)
+}
+
+func clamp8(v float64) uint8 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// Apply remaps an image to the palette `p`, mapping each pixel to its nearest palette color in the color space `s`
+func Apply(p []color.RGBA, i image.Image, s ColorSpace, d Dither) (*image.Paletted, error) {
+	if len(p) == 0 {
+		return nil, errors.New("empty palette")
+	}
+	if len(p) > 256 {
+		return nil, fmt.Errorf("palette has %d colors, at most 256 are supported", len(p))
+	}
+	cp := make(color.Palette, len(p))
+	for j, c := range p {
+		c.A = 255
+		cp[j] = c
+	}
+	bounds := i.Bounds()
+	size := bounds.Size()
+	out := image.NewPaletted(image.Rectangle{Max: size}, cp)
+	nearest := newNearestColor(p, s)
+
+	// errors of the current and the next row, with one pixel of padding on each side
+	var errCur, errNext [][3]float64
+	if d == DitherFloydSteinberg {
+		errCur = make([][3]float64, size.X+2)
+		errNext = make([][3]float64, size.X+2)
+	}
+	spread := 255.0 / float64(len(p))
+
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			r, g, b, _ := i.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			v := [3]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8)}
+			switch d {
+			case DitherFloydSteinberg:
+				for c := range v {
+					v[c] += errCur[x+1][c]
+				}
+			case DitherOrdered:
+				t := (bayer8[y%8][x%8]/64 - 0.5) * spread
+				for c := range v {
+					v[c] += t
+				}
+			}
+			j := nearest.index(color.RGBA{R: clamp8(v[0]), G: clamp8(v[1]), B: clamp8(v[2]), A: 255})
+			out.SetColorIndex(x, y, j)
+			if d == DitherFloydSteinberg {
+				q := p[j]
+				e := [3]float64{v[0] - float64(q.R), v[1] - float64(q.G), v[2] - float64(q.B)}
+				for c := range e {
+					errCur[x+2][c] += e[c] * 7 / 16
+					errNext[x][c] += e[c] * 3 / 16
+					errNext[x+1][c] += e[c] * 5 / 16
+					errNext[x+2][c] += e[c] * 1 / 16
+				}
+			}
+		}
+		if d == DitherFloydSteinberg {
+			errCur, errNext = errNext, errCur
+			for x := range errNext {
+				errNext[x] = [3]float64{}
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package palette
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// nearestIndex returns the index of the palette color nearest to `c` in the color space `s`,
+// the first one in case of ties
+func nearestIndex(p []color.RGBA, c color.RGBA, s ColorSpace) int {
+	q := s.Point(c)
+	best, bestDistance := 0, math.Inf(1)
+	for i, pc := range p {
+		pp := s.Point(pc)
+		d0, d1, d2 := pp[0]-q[0], pp[1]-q[1], pp[2]-q[2]
+		if d := d0*d0 + d1*d1 + d2*d2; d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return best
+}
+
+func TestApplyNearest(t *testing.T) {
+	p := randomPalettes(1, 6)[0]
+	noise := noiseImage(32)
+	// the same pixels at an offset
+	offset := image.NewRGBA(image.Rect(-5, 7, 27, 39))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			offset.SetRGBA(x-5, y+7, noise.RGBAAt(x, y))
+		}
+	}
+	for _, s := range []ColorSpace{ColorSpaceRGB, ColorSpaceHSL, ColorSpaceLab} {
+		for name, i := range map[string]*image.RGBA{"origin": noise, "offset": offset} {
+			out, err := Apply(p, i, s, DitherNone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.Bounds() != image.Rect(0, 0, 32, 32) {
+				t.Fatalf("%v/%s: bounds %v, want %v", s, name, out.Bounds(), image.Rect(0, 0, 32, 32))
+			}
+			if len(out.Palette) != len(p) {
+				t.Fatalf("%v/%s: %d palette colors, want %d", s, name, len(out.Palette), len(p))
+			}
+			b := i.Bounds()
+			for y := 0; y < 32; y++ {
+				for x := 0; x < 32; x++ {
+					c := i.RGBAAt(b.Min.X+x, b.Min.Y+y)
+					if got, want := int(out.ColorIndexAt(x, y)), nearestIndex(p, c, s); got != want {
+						t.Fatalf("%v/%s: pixel (%d,%d) %v mapped to %v, want nearest %v", s, name, x, y, c, p[got], p[want])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestApplyDither(t *testing.T) {
+	grey := image.NewUniform(color.RGBA{128, 128, 128, 255})
+	i := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			i.Set(x, y, grey.C)
+		}
+	}
+	p := []color.RGBA{{0, 0, 0, 255}, {255, 255, 255, 255}}
+	tests := []struct {
+		dither   Dither
+		min, max float64
+	}{
+		// without dithering, every pixel is mapped to the nearer color, white
+		{DitherNone, 1, 1},
+		// with dithering, roughly half of the pixels are white (ordered dithering picks the nearer color
+		// in the color space, which is not exactly half-way in Lab)
+		{DitherFloydSteinberg, 0.4, 0.6},
+		{DitherOrdered, 0.4, 0.6},
+	}
+	for _, tt := range tests {
+		for _, s := range []ColorSpace{ColorSpaceRGB, ColorSpaceLab} {
+			out, err := Apply(p, i, s, tt.dither)
+			if err != nil {
+				t.Fatal(err)
+			}
+			white := 0
+			for _, j := range out.Pix {
+				white += int(j)
+			}
+			if coverage := float64(white) / float64(len(out.Pix)); coverage < tt.min || coverage > tt.max {
+				t.Errorf("%v/%v: %.3f of the pixels are white, want [%.2f, %.2f]", tt.dither, s, coverage, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	i := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	if _, err := Apply(nil, i, ColorSpaceLab, DitherNone); err == nil {
+		t.Error("Apply with an empty palette succeeded")
+	}
+	if _, err := Apply(make([]color.RGBA, 257), i, ColorSpaceLab, DitherNone); err == nil {
+		t.Error("Apply with 257 colors succeeded")
+	}
+}
+
+func TestParseDither(t *testing.T) {
+	for i, name := range Dithers {
+		if d, err := ParseDither(name); err != nil || d != Dither(i) || d.String() != name {
+			t.Errorf("ParseDither(%q) = %v, %v", name, d, err)
+		}
+	}
+	if _, err := ParseDither("halftone"); err == nil {
+		t.Error("ParseDither of an unknown method succeeded")
+	}
+}
@@ -1,47 +1,75 @@
 package palette
 
 import (
-	"encoding/binary"
-	"sync"
-
-	"github.com/oxtoacart/bpool"
+	"math"
+	"sync/atomic"
 )
 
+// ColorCache memoizes RGB to HSL conversions in a fixed-size, direct-mapped table
+// keyed on the packed 24-bit RGB color. It is safe for concurrent use without locks:
+// each slot is guarded by a sequence number, and readers that observe a concurrent
+// write simply recompute the conversion. Memory use is bounded by the table size
+// (32 bytes per slot), independent of the number of distinct colors seen.
 type ColorCache struct {
-	sync.Mutex
-	Colors     map[string][]float64
-	BufferPool *bpool.BufferPool
+	slots []colorCacheSlot
+	mask  uint32
 }
 
-func (c *ColorCache) Key(r, g, b uint32) (key string) {
-	buf := c.BufferPool.Get()
-	defer c.BufferPool.Put(buf)
-	binary.Write(buf, binary.LittleEndian, r)
-	binary.Write(buf, binary.LittleEndian, g)
-	binary.Write(buf, binary.LittleEndian, b)
-	key = buf.String()
-	return
+type colorCacheSlot struct {
+	// seq holds a write counter in its upper 32 bits and (key+1)<<1 in its lower bits;
+	// it is odd while the slot is being written
+	seq     uint64
+	h, s, l uint64
 }
 
-func (c *ColorCache) Get(r, g, b uint32) []float64 {
+// NewColorCache returns a cache with at least `size` slots (rounded up to a power of two)
+func NewColorCache(size int) *ColorCache {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &ColorCache{
+		slots: make([]colorCacheSlot, n),
+		mask:  uint32(n - 1),
+	}
+}
+
+// Key packs the 8 most significant bits of each 16-bit channel into a 24-bit key
+func (c *ColorCache) Key(r, g, b uint32) uint32 {
+	return (r>>8)<<16 | (g>>8)<<8 | b>>8
+}
+
+// slot spreads keys over the table so that neighbouring colors do not collide
+func (c *ColorCache) slot(key uint32) *colorCacheSlot {
+	return &c.slots[(key*0x9e3779b1)>>8&c.mask]
+}
+
+// Point returns the HSL point of a 16-bit RGB color without allocating
+func (c *ColorCache) Point(r, g, b uint32) (point [3]float64) {
 	key := c.Key(r, g, b)
-	c.Lock()
-	defer c.Unlock()
-	if point, ok := c.Colors[key]; ok {
-		return point
+	tag := uint64(key+1) << 1
+	slot := c.slot(key)
+	seq := atomic.LoadUint64(&slot.seq)
+	if uint32(seq) == uint32(tag) {
+		point[0] = math.Float64frombits(atomic.LoadUint64(&slot.h))
+		point[1] = math.Float64frombits(atomic.LoadUint64(&slot.s))
+		point[2] = math.Float64frombits(atomic.LoadUint64(&slot.l))
+		if atomic.LoadUint64(&slot.seq) == seq {
+			return point
+		}
+	}
+	point[0], point[1], point[2] = hsl(uint8(key>>16), uint8(key>>8), uint8(key))
+	if seq&1 == 0 && atomic.CompareAndSwapUint64(&slot.seq, seq, seq|1) {
+		atomic.StoreUint64(&slot.h, math.Float64bits(point[0]))
+		atomic.StoreUint64(&slot.s, math.Float64bits(point[1]))
+		atomic.StoreUint64(&slot.l, math.Float64bits(point[2]))
+		atomic.StoreUint64(&slot.seq, (seq>>32+1)<<32|tag)
 	}
-	point := make([]float64, 3)
-	h, s, l := hsl(uint8(float64(r)/256.0), uint8(float64(g)/256.0), uint8(float64(b)/256.0))
-	point[0] = h // float64(r) / float64(0xffff)
-	point[1] = s // float64(g) / float64(0xffff)
-	point[2] = l // float64(b) / float64(0xffff)
-	c.Colors[key] = point
 	return point
 }
 
-func NewColorCache(size int) *ColorCache {
-	return &ColorCache{
-		Colors:     make(map[string][]float64, size),
-		BufferPool: bpool.NewBufferPool(size),
-	}
+// Get returns the HSL point of a 16-bit RGB color as a newly allocated slice
+func (c *ColorCache) Get(r, g, b uint32) []float64 {
+	point := c.Point(r, g, b)
+	return point[:]
 }
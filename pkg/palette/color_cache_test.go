@@ -0,0 +1,111 @@
+package palette
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// randomColors returns `n` deterministic random 16-bit RGB colors
+func randomColors(n int) [][3]uint32 {
+	rng := rand.New(rand.NewSource(int64(n)))
+	out := make([][3]uint32, n)
+	for i := range out {
+		out[i] = [3]uint32{uint32(rng.Intn(1 << 16)), uint32(rng.Intn(1 << 16)), uint32(rng.Intn(1 << 16))}
+	}
+	return out
+}
+
+// checkPoint compares a cached point with the direct conversion of the color
+func checkPoint(c [3]uint32, got [3]float64) error {
+	h, s, l := hsl(uint8(c[0]>>8), uint8(c[1]>>8), uint8(c[2]>>8))
+	if got != [3]float64{h, s, l} {
+		return fmt.Errorf("Point(%d, %d, %d) = %v, want %v", c[0], c[1], c[2], got, [3]float64{h, s, l})
+	}
+	return nil
+}
+
+func TestColorCachePoint(t *testing.T) {
+	cache := NewColorCache(1 << 10)
+	colors := randomColors(1 << 12)
+	// twice, so that the second pass reads the values cached by the first
+	for pass := 0; pass < 2; pass++ {
+		for _, c := range colors {
+			if err := checkPoint(c, cache.Point(c[0], c[1], c[2])); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestColorCacheConcurrent checks cached points against direct conversion while many goroutines
+// read and write the same slots. Run it with -race.
+func TestColorCacheConcurrent(t *testing.T) {
+	colors := randomColors(1 << 10)
+	for _, size := range []int{1, 16, 1 << 16} {
+		t.Run(fmt.Sprintf("slots=%d", size), func(t *testing.T) {
+			cache := NewColorCache(size)
+			if size == 16 {
+				// make sure that some of the colors share a slot
+				slots := make(map[*colorCacheSlot]bool)
+				for _, c := range colors {
+					slots[cache.slot(cache.Key(c[0], c[1], c[2]))] = true
+				}
+				if len(slots) >= len(colors) {
+					t.Fatal("no slot collisions")
+				}
+			}
+			const goroutines = 8
+			errs := make(chan error, goroutines)
+			var wg sync.WaitGroup
+			wg.Add(goroutines)
+			for g := 0; g < goroutines; g++ {
+				go func(g int) {
+					defer wg.Done()
+					for n := 0; n < 4*len(colors); n++ {
+						// each goroutine walks the colors with a different stride
+						c := colors[(n*(2*g+1))%len(colors)]
+						if err := checkPoint(c, cache.Point(c[0], c[1], c[2])); err != nil {
+							errs <- err
+							return
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func BenchmarkColorCache(b *testing.B) {
+	colors := randomColors(1 << 16)
+	for _, size := range []int{1 << 10, 1 << 16} {
+		b.Run(fmt.Sprintf("Point/slots=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			cache := NewColorCache(size)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				c := colors[n%len(colors)]
+				cache.Point(c[0], c[1], c[2])
+			}
+		})
+		b.Run(fmt.Sprintf("PointParallel/slots=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			cache := NewColorCache(size)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				n := 0
+				for pb.Next() {
+					c := colors[n%len(colors)]
+					cache.Point(c[0], c[1], c[2])
+					n++
+				}
+			})
+		})
+	}
+}
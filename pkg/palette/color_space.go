@@ -0,0 +1,69 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// ColorSpace is a color space in which colors are compared
+type ColorSpace int
+
+const (
+	// ColorSpaceRGB compares colors by their sRGB components
+	ColorSpaceRGB ColorSpace = iota
+	// ColorSpaceHSL compares colors by their weighted HSL components
+	ColorSpaceHSL
+	// ColorSpaceLab compares colors by their CIE L*a*b* components
+	ColorSpaceLab
+)
+
+// ColorSpaces lists the names of all color spaces
+var ColorSpaces = []string{"rgb", "hsl", "lab"}
+
+func (s ColorSpace) String() string {
+	if int(s) < len(ColorSpaces) {
+		return ColorSpaces[s]
+	}
+	return fmt.Sprintf("ColorSpace(%d)", int(s))
+}
+
+// ParseColorSpace returns the color space with the given name
+func ParseColorSpace(name string) (ColorSpace, error) {
+	for i, n := range ColorSpaces {
+		if strings.EqualFold(n, name) {
+			return ColorSpace(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown color space %q", name)
+}
+
+// Point returns the coordinates of a color in the color space
+func (s ColorSpace) Point(c color.RGBA) (p [3]float64) {
+	switch s {
+	case ColorSpaceHSL:
+		h, sat, l := hsl(c.R, c.G, c.B)
+		p = [3]float64{h * weightH, sat * weightS, l * weightL}
+	case ColorSpaceLab:
+		p[0], p[1], p[2] = lab(c.R, c.G, c.B)
+	default:
+		p = [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+	}
+	return
+}
+
+// Color returns the sRGB color at the given coordinates of the color space, clamped to the sRGB gamut
+func (s ColorSpace) Color(p [3]float64) color.RGBA {
+	clamp := func(v float64) float64 { return math.Max(0, math.Min(1, v)) }
+	c := color.RGBA{A: 255}
+	switch s {
+	case ColorSpaceHSL:
+		c.R, c.G, c.B = rgb(clamp(p[0]/weightH), clamp(p[1]/weightS), clamp(p[2]/weightL))
+	case ColorSpaceLab:
+		c.R, c.G, c.B = labRGB(p[0], p[1], p[2])
+	default:
+		c.R, c.G, c.B = uint8(math.Round(255*clamp(p[0]))), uint8(math.Round(255*clamp(p[1]))), uint8(math.Round(255*clamp(p[2])))
+	}
+	return c
+}
@@ -0,0 +1,72 @@
+package palette
+
+import (
+	"image/color"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// checkRoundTrip checks that `f` returns every opaque 8-bit sRGB color unchanged. With -short,
+// only every 97th color is checked; since 97 is prime, every channel still takes all values.
+func checkRoundTrip(t *testing.T, f func(c color.RGBA) color.RGBA) {
+	t.Helper()
+	step := 1
+	if testing.Short() {
+		step = 97
+	}
+	workers := runtime.GOMAXPROCS(0)
+	var mu sync.Mutex
+	var mismatches, maxError int
+	var example [2]color.RGBA
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			n, max := 0, 0
+			var first [2]color.RGBA
+			for v := w * step; v < 1<<24; v += workers * step {
+				c := color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}
+				out := f(c)
+				if out == c {
+					continue
+				}
+				if n == 0 {
+					first = [2]color.RGBA{c, out}
+				}
+				n++
+				for _, d := range []int{int(out.R) - int(c.R), int(out.G) - int(c.G), int(out.B) - int(c.B)} {
+					if d < 0 {
+						d = -d
+					}
+					if d > max {
+						max = d
+					}
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if n > 0 && mismatches == 0 {
+				example = first
+			}
+			mismatches += n
+			if max > maxError {
+				maxError = max
+			}
+		}(w)
+	}
+	wg.Wait()
+	if mismatches > 0 {
+		t.Errorf("%d colors changed, by up to %d per channel; e.g. %v became %v", mismatches, maxError, example[0], example[1])
+	}
+}
+
+func TestColorSpaceRoundTrip(t *testing.T) {
+	for j, name := range ColorSpaces {
+		space := ColorSpace(j)
+		t.Run(name, func(t *testing.T) {
+			checkRoundTrip(t, func(c color.RGBA) color.RGBA { return space.Color(space.Point(c)) })
+		})
+	}
+}
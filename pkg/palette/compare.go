@@ -1,10 +1,17 @@
 package palette
 
-import "image/color"
+import (
+	"image/color"
+	"math"
+)
 
-func LessLSH(cs []color.RGBA, i, j int) bool {
-	hi, si, li := hsl(cs[i].R, cs[i].G, cs[i].B)
-	hj, sj, lj := hsl(cs[j].R, cs[j].G, cs[j].B)
+// Order reports whether color `a` sorts before color `b`
+type Order func(a, b color.RGBA) bool
+
+// OrderLSH orders colors by lightness, then saturation, then hue
+func OrderLSH(a, b color.RGBA) bool {
+	hi, si, li := hsl(a.R, a.G, a.B)
+	hj, sj, lj := hsl(b.R, b.G, b.B)
 	if li == lj {
 		if si == sj {
 			return hi < hj
@@ -14,9 +21,10 @@ func LessLSH(cs []color.RGBA, i, j int) bool {
 	return li < lj
 }
 
-func LessHLS(cs []color.RGBA, i, j int) bool {
-	hi, si, li := hsl(cs[i].R, cs[i].G, cs[i].B)
-	hj, sj, lj := hsl(cs[j].R, cs[j].G, cs[j].B)
+// OrderHLS orders colors by hue, then lightness, then saturation
+func OrderHLS(a, b color.RGBA) bool {
+	hi, si, li := hsl(a.R, a.G, a.B)
+	hj, sj, lj := hsl(b.R, b.G, b.B)
 	if hi == hj {
 		if li == lj {
 			return si < sj
@@ -26,9 +34,10 @@ func LessHLS(cs []color.RGBA, i, j int) bool {
 	return hi < hj
 }
 
-func LessLHS(cs []color.RGBA, i, j int) bool {
-	hi, si, li := hsl(cs[i].R, cs[i].G, cs[i].B)
-	hj, sj, lj := hsl(cs[j].R, cs[j].G, cs[j].B)
+// OrderLHS orders colors by lightness, then hue, then saturation
+func OrderLHS(a, b color.RGBA) bool {
+	hi, si, li := hsl(a.R, a.G, a.B)
+	hj, sj, lj := hsl(b.R, b.G, b.B)
 	if li == lj {
 		if hi == hj {
 			return si < sj
@@ -37,3 +46,73 @@ func LessLHS(cs []color.RGBA, i, j int) bool {
 	}
 	return li < lj
 }
+
+// Orders maps names to color orderings
+var Orders = map[string]Order{
+	"lsh": OrderLSH,
+	"hls": OrderHLS,
+	"lhs": OrderLHS,
+}
+
+func LessLSH(cs []color.RGBA, i, j int) bool {
+	return OrderLSH(cs[i], cs[j])
+}
+
+func LessHLS(cs []color.RGBA, i, j int) bool {
+	return OrderHLS(cs[i], cs[j])
+}
+
+func LessLHS(cs []color.RGBA, i, j int) bool {
+	return OrderLHS(cs[i], cs[j])
+}
+
+// SpaceDistance returns the distance between two palettes of equal size in a color space, comparing colors in order
+func SpaceDistance(p, q []color.RGBA, s ColorSpace) float64 {
+	var sum float64
+	for i := range p {
+		a, b := s.Point(p[i]), s.Point(q[i])
+		for d := range a {
+			sum += (a[d] - b[d]) * (a[d] - b[d])
+		}
+	}
+	return math.Sqrt(sum)
+}
+
+func nearestDistance(p []color.RGBA, q [][3]float64, s ColorSpace) float64 {
+	var sum float64
+	for _, c := range p {
+		a := s.Point(c)
+		min := math.Inf(1)
+		for _, b := range q {
+			d0, d1, d2 := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+			if d := d0*d0 + d1*d1 + d2*d2; d < min {
+				min = d
+			}
+		}
+		sum += math.Sqrt(min)
+	}
+	return sum / float64(len(p))
+}
+
+func points(p []color.RGBA, s ColorSpace) [][3]float64 {
+	out := make([][3]float64, len(p))
+	for i, c := range p {
+		out[i] = s.Point(c)
+	}
+	return out
+}
+
+// CoverDistance returns the mean distance of each color of `query` to its nearest color in `p`.
+// It is small when all query colors occur in `p`, regardless of the other colors of `p`.
+func CoverDistance(query, p []color.RGBA, s ColorSpace) float64 {
+	if len(query) == 0 || len(p) == 0 {
+		return math.Inf(1)
+	}
+	return nearestDistance(query, points(p, s), s)
+}
+
+// MatchDistance is an order-invariant distance between two palettes of any size:
+// the mean of the `CoverDistance`s in both directions.
+func MatchDistance(p, q []color.RGBA, s ColorSpace) float64 {
+	return (CoverDistance(p, q, s) + CoverDistance(q, p, s)) / 2
+}
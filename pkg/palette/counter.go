@@ -0,0 +1,79 @@
+package palette
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math/rand"
+)
+
+// counterCompactKeys is the number of buffered pixel keys after which a ColorCounter compacts its histogram
+const counterCompactKeys = 1 << 20
+
+// ColorCounter counts the colors of an image that is added strip by strip, e.g. while it is
+// being decoded by input.StreamPNG. Its memory use is bounded by the number of distinct colors
+// (at most 2^24), or by `Sample` if set, regardless of the image dimensions.
+type ColorCounter struct {
+	options   Options
+	rng       *rand.Rand
+	h         histogram
+	reservoir []uint32
+	seen      int
+}
+
+// NewColorCounter returns a counter for extracting a palette with the given options.
+// If `o.Sample` is set, a uniform random sample of that many pixels is kept (reservoir sampling),
+// which differs from the sampling of ExtractWithOptions.
+func NewColorCounter(o Options) (*ColorCounter, error) {
+	o, err := o.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return &ColorCounter{options: o, rng: rand.New(rand.NewSource(o.Seed))}, nil
+}
+
+// Add counts the pixels of a strip. The strip is not retained.
+func (c *ColorCounter) Add(strip image.Image) error {
+	if c.options.Sample <= 0 {
+		if err := c.h.addImage(context.Background(), strip, 0, nil); err != nil {
+			return err
+		}
+		if len(c.h.keys)+len(c.h.weighted) >= counterCompactKeys {
+			c.h.compact()
+		}
+		return nil
+	}
+	bounds := strip.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c.seen++
+			j := len(c.reservoir)
+			if j >= c.options.Sample {
+				j = c.rng.Intn(c.seen)
+				if j >= c.options.Sample {
+					continue
+				}
+			}
+			r, g, b, _ := strip.At(x, y).RGBA()
+			key := (r>>8)<<16 | (g>>8)<<8 | b>>8
+			if j == len(c.reservoir) {
+				c.reservoir = append(c.reservoir, key)
+			} else {
+				c.reservoir[j] = key
+			}
+		}
+	}
+	return nil
+}
+
+// Extract extracts a palette of `o.K` colors from the pixels added so far
+func (c *ColorCounter) Extract(ctx context.Context) (*ExtractResult, error) {
+	if c.options.Sample > 0 {
+		if len(c.reservoir) == 0 {
+			return nil, errors.New("empty image")
+		}
+		c.h = histogram{keys: c.reservoir, pixels: len(c.reservoir)}
+		c.reservoir = nil
+	}
+	return extractHistogram(ctx, &c.h, c.options, c.rng)
+}
@@ -0,0 +1,199 @@
+package palette
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func formatAlpha(a uint8) string {
+	if a == 255 {
+		return ""
+	}
+	return " / " + strconv.FormatFloat(math.Round(float64(a)/255*1000)/1000, 'f', -1, 64)
+}
+
+// FormatRGB formats a color as a CSS `rgb()` function, e.g. `rgb(255 128 0)`
+func FormatRGB(c color.RGBA) string {
+	return fmt.Sprintf("rgb(%d %d %d%s)", c.R, c.G, c.B, formatAlpha(c.A))
+}
+
+// FormatHSL formats a color as a CSS `hsl()` function, e.g. `hsl(30 100% 50%)`
+func FormatHSL(c color.RGBA) string {
+	h, s, l := hsl(c.R, c.G, c.B)
+	return fmt.Sprintf("hsl(%s %s%% %s%%%s)", formatNumber(h*360, 1), formatNumber(s*100, 1), formatNumber(l*100, 1), formatAlpha(c.A))
+}
+
+// FormatOKLCH formats a color as a CSS `oklch()` function, e.g. `oklch(74.42% 0.18117 56.46)`, with enough digits to round-trip 8-bit colors exactly
+func FormatOKLCH(c color.RGBA) string {
+	l, ch, h := oklch(c.R, c.G, c.B)
+	return fmt.Sprintf("oklch(%s%% %s %s%s)", formatNumber(l*100, 3), formatNumber(ch, 5), formatNumber(h, 2), formatAlpha(c.A))
+}
+
+func formatNumber(v float64, digits int) string {
+	scale := math.Pow(10, float64(digits))
+	return strconv.FormatFloat(math.Round(v*scale)/scale, 'f', -1, 64)
+}
+
+// ParseHex parses a color given as `#rgb`, `#rgba`, `#rrggbb` or `#rrggbbaa` (the `#` is optional)
+func ParseHex(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(s) == 3 || len(s) == 4 {
+		var long []byte
+		for i := range s {
+			long = append(long, s[i], s[i])
+		}
+		s = string(long)
+	}
+	if len(s) != 6 && len(s) != 8 {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", s)
+	}
+	if len(s) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.RGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// ParseColor parses a color given as a hex color (see ParseHex) or as a CSS
+// `rgb()`, `rgba()`, `hsl()`, `hsla()` or `oklch()` function, in either the comma-separated
+// or the space-separated syntax, e.g. `rgb(255, 128, 0)` or `oklch(70% 0.15 60 / 50%)`.
+// Colors outside the sRGB gamut are clamped.
+func ParseColor(s string) (color.RGBA, error) {
+	s = strings.TrimSpace(s)
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		return ParseHex(s)
+	}
+	if !strings.HasSuffix(s, ")") {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: missing )", s)
+	}
+	name := strings.ToLower(strings.TrimSpace(s[:open]))
+	args, alpha, err := splitArgs(s[open+1 : len(s)-1])
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+	}
+	if len(args) != 3 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: expected 3 components and an optional alpha", s)
+	}
+	c := color.RGBA{A: 255}
+	if alpha != "" {
+		a, err := parseFraction(alpha, 1)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		c.A = uint8(math.Round(255 * a))
+	}
+	switch name {
+	case "rgb", "rgba":
+		var v [3]float64
+		for i, arg := range args {
+			if v[i], err = parseFraction(arg, 255); err != nil {
+				return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+			}
+		}
+		c.R, c.G, c.B = uint8(math.Round(255*v[0])), uint8(math.Round(255*v[1])), uint8(math.Round(255*v[2]))
+	case "hsl", "hsla":
+		h, err := parseHue(args[0])
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		sat, err := parseFraction(args[1], 100)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		l, err := parseFraction(args[2], 100)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		c.R, c.G, c.B = rgb(h/360, sat, l)
+	case "oklch":
+		l, err := parseFraction(args[0], 1)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		chroma, err := parseNumber(args[1], 0.4)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		h, err := parseHue(args[2])
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+		}
+		c.R, c.G, c.B = oklchRGB(l, math.Max(0, chroma), h)
+	default:
+		return color.RGBA{}, fmt.Errorf("invalid color %q: unknown function %q", s, name)
+	}
+	return c, nil
+}
+
+// splitArgs splits the arguments of a CSS color function into components and alpha
+func splitArgs(s string) (args []string, alpha string, err error) {
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		alpha = strings.TrimSpace(s[i+1:])
+		s = s[:i]
+		if alpha == "" {
+			return nil, "", fmt.Errorf("missing alpha")
+		}
+	}
+	if strings.Contains(s, ",") {
+		for _, arg := range strings.Split(s, ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+		if len(args) == 4 && alpha == "" {
+			args, alpha = args[:3], args[3]
+		}
+		return args, alpha, nil
+	}
+	return strings.Fields(s), alpha, nil
+}
+
+// parseNumber parses a number, or a percentage of `percent`
+func parseNumber(s string, percent float64) (float64, error) {
+	scale := 1.0
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), percent/100
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return v * scale, nil
+}
+
+// parseFraction parses a number in [0, `max`] or a percentage, and returns it as a fraction in [0, 1]
+func parseFraction(s string, max float64) (float64, error) {
+	v, err := parseNumber(s, max)
+	if err != nil {
+		return 0, err
+	}
+	return math.Max(0, math.Min(1, v/max)), nil
+}
+
+// parseHue parses an angle in degrees, or with a `deg`, `rad`, `grad` or `turn` unit, and returns it in degrees in [0, 360)
+func parseHue(s string) (float64, error) {
+	scale := 1.0
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{{"deg", 1}, {"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, scale = strings.TrimSuffix(s, unit.suffix), unit.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hue %q", s)
+	}
+	v = math.Mod(v*scale, 360)
+	if v < 0 {
+		v += 360
+	}
+	return v, nil
+}
@@ -0,0 +1,31 @@
+package palette
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestCSSRoundTrip(t *testing.T) {
+	formats := []struct {
+		name   string
+		format func(color.RGBA) string
+	}{
+		{"hex", Hex},
+		{"rgb", FormatRGB},
+		{"hsl", FormatHSL},
+		{"oklch", FormatOKLCH},
+	}
+	for _, f := range formats {
+		format := f.format
+		t.Run(f.name, func(t *testing.T) {
+			checkRoundTrip(t, func(c color.RGBA) color.RGBA {
+				out, err := ParseColor(format(c))
+				if err != nil {
+					// reported as a changed color
+					return color.RGBA{}
+				}
+				return out
+			})
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package palette
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"sort"
+)
+
+// ExtractResult is a palette extracted by ExtractWithOptions or ExtractFramesWithOptions
+type ExtractResult struct {
+	// Palette holds the K extracted colors, ordered by lightness, hue and saturation
+	Palette []color.RGBA
+	// Weights holds the fraction of the used pixels nearest to each palette color
+	Weights []float64
+	// Pixels is the number of pixels used (after sampling)
+	Pixels int
+	// Colors is the number of distinct colors among the used pixels
+	Colors int
+	// Iterations is the number of k-means iterations run
+	Iterations int
+	// Options are the options used, with defaults filled in
+	Options Options
+}
+
+// ClusterResult is a clustering of palettes made by ClusterWithOptions
+type ClusterResult struct {
+	// Labels holds the cluster of each palette
+	Labels []int
+	// Centroids holds the mean palette of each cluster
+	Centroids [][]color.RGBA
+	// Sizes holds the number of palettes in each cluster
+	Sizes []int
+	// Iterations is the number of k-means iterations run
+	Iterations int
+	// Options are the options used, with defaults filled in
+	Options Options
+}
+
+// histogram counts the distinct colors of images. Pixels are first collected as packed
+// 24-bit RGB keys (4 bytes each) and merged into distinct colors by compact.
+type histogram struct {
+	keys     []uint32
+	weighted []weightedKey
+	colors   []color.RGBA
+	counts   []float64
+	pixels   int
+}
+
+type weightedKey struct {
+	key    uint32
+	weight float64
+}
+
+func (h *histogram) add(r, g, b uint32, n float64) {
+	key := (r>>8)<<16 | (g>>8)<<8 | b>>8
+	if n == 1 {
+		h.keys = append(h.keys, key)
+		return
+	}
+	h.weighted = append(h.weighted, weightedKey{key, n})
+}
+
+// grow makes room for `n` more pixel keys, avoiding repeated reallocation of large images
+func (h *histogram) grow(n int) {
+	if cap(h.keys)-len(h.keys) < n {
+		size := len(h.keys) + n
+		if size < 2*cap(h.keys) {
+			size = 2 * cap(h.keys)
+		}
+		keys := make([]uint32, len(h.keys), size)
+		copy(keys, h.keys)
+		h.keys = keys
+	}
+}
+
+// compact merges the collected keys, and any previously compacted colors, into distinct colors
+// and their counts, ordered by key
+func (h *histogram) compact() {
+	for j, c := range h.colors {
+		h.weighted = append(h.weighted, weightedKey{uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B), h.counts[j]})
+	}
+	sortKeys24(h.keys)
+	distinct := 0
+	for j := range h.keys {
+		if j == 0 || h.keys[j] != h.keys[j-1] {
+			distinct++
+		}
+	}
+	sort.SliceStable(h.weighted, func(i, j int) bool { return h.weighted[i].key < h.weighted[j].key })
+	h.colors = make([]color.RGBA, 0, distinct+len(h.weighted))
+	h.counts = make([]float64, 0, distinct+len(h.weighted))
+	add := func(key uint32, n float64) {
+		if last := len(h.colors) - 1; last >= 0 && h.colors[last] == keyColor(key) {
+			h.counts[last] += n
+			return
+		}
+		h.colors = append(h.colors, keyColor(key))
+		h.counts = append(h.counts, n)
+	}
+	// merge the sorted runs of pixel keys with the sorted weighted keys
+	w := 0
+	for j := 0; j < len(h.keys); {
+		run := j
+		for j < len(h.keys) && h.keys[j] == h.keys[run] {
+			j++
+		}
+		for ; w < len(h.weighted) && h.weighted[w].key <= h.keys[run]; w++ {
+			add(h.weighted[w].key, h.weighted[w].weight)
+		}
+		add(h.keys[run], float64(j-run))
+	}
+	for ; w < len(h.weighted); w++ {
+		add(h.weighted[w].key, h.weighted[w].weight)
+	}
+	h.keys, h.weighted = nil, nil
+}
+
+func keyColor(key uint32) color.RGBA {
+	return color.RGBA{R: uint8(key >> 16), G: uint8(key >> 8), B: uint8(key), A: 255}
+}
+
+// sortKeys24 sorts 24-bit keys in place by a three-pass radix sort
+func sortKeys24(keys []uint32) {
+	tmp := make([]uint32, len(keys))
+	src, dst := keys, tmp
+	for shift := uint(0); shift < 24; shift += 8 {
+		var offsets [257]int
+		for _, k := range src {
+			offsets[(k>>shift)&0xff+1]++
+		}
+		for i := 1; i < len(offsets); i++ {
+			offsets[i] += offsets[i-1]
+		}
+		for _, k := range src {
+			b := (k >> shift) & 0xff
+			dst[offsets[b]] = k
+			offsets[b]++
+		}
+		src, dst = dst, src
+	}
+	copy(keys, src)
+}
+
+// addImage counts the colors of all pixels of an image, or of `sample` random pixels if it has more
+func (h *histogram) addImage(ctx context.Context, i image.Image, sample int, rng *rand.Rand) error {
+	bounds := i.Bounds()
+	w, ht := bounds.Dx(), bounds.Dy()
+	if sample > 0 && sample < w*ht {
+		h.grow(sample)
+		for j := 0; j < sample; j++ {
+			if j%4096 == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			r, g, b, _ := i.At(bounds.Min.X+rng.Intn(w), bounds.Min.Y+rng.Intn(ht)).RGBA()
+			h.add(r, g, b, 1)
+		}
+		h.pixels += sample
+		return nil
+	}
+	if pi, ok := i.(*image.Paletted); ok {
+		counts := make([]float64, len(pi.Palette))
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for _, j := range pi.Pix[pi.PixOffset(bounds.Min.X, y):pi.PixOffset(bounds.Max.X, y)] {
+				if int(j) < len(counts) {
+					counts[j]++
+				}
+			}
+		}
+		for j, c := range pi.Palette {
+			if counts[j] > 0 {
+				r, g, b, _ := c.RGBA()
+				h.add(r, g, b, counts[j])
+			}
+		}
+		h.pixels += w * ht
+		return ctx.Err()
+	}
+	h.grow(w * ht)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := i.At(x, y).RGBA()
+			h.add(r, g, b, 1)
+		}
+	}
+	h.pixels += w * ht
+	return nil
+}
+
+// ExtractWithOptions extracts a palette of `o.K` colors from an image.
+// Besides the decoded image, it needs about 8 bytes per used pixel while counting colors
+// and about 32 bytes per distinct color while clustering: at most about 30 MB per megapixel,
+// and much less for images with few distinct colors or with `o.Sample` set (see BenchmarkExtractMemory).
+func ExtractWithOptions(ctx context.Context, i image.Image, o Options) (*ExtractResult, error) {
+	return ExtractFramesWithOptions(ctx, []image.Image{i}, o)
+}
+
+// ExtractFramesWithOptions extracts a palette of `o.K` colors from all frames of an animation,
+// weighting each frame by its (sampled) pixel count
+func ExtractFramesWithOptions(ctx context.Context, frames []image.Image, o Options) (*ExtractResult, error) {
+	o, err := o.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(o.Seed))
+	h := &histogram{}
+	for _, i := range frames {
+		if err := h.addImage(ctx, i, o.Sample, rng); err != nil {
+			return nil, err
+		}
+	}
+	return extractHistogram(ctx, h, o, rng)
+}
+
+// extractHistogram clusters the counted colors of a histogram
+func extractHistogram(ctx context.Context, h *histogram, o Options, rng *rand.Rand) (*ExtractResult, error) {
+	h.compact()
+	if len(h.colors) == 0 {
+		return nil, errors.New("empty image")
+	}
+	points := newPointSet(3, len(h.colors))
+	for _, c := range h.colors {
+		points.add3(o.ColorSpace.Point(c))
+	}
+	km, err := clusterPoints(ctx, points, h.counts, o, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make([]float64, len(km.means))
+	for j, label := range km.labels {
+		weights[label] += h.counts[j] / float64(h.pixels)
+	}
+	colors := make([]color.RGBA, len(km.means))
+	for j, m := range km.means {
+		colors[j] = o.ColorSpace.Color([3]float64{m[0], m[1], m[2]})
+	}
+	order := make([]int, len(colors))
+	for j := range order {
+		order[j] = j
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return OrderLHS(colors[order[i]], colors[order[j]])
+	})
+
+	out := &ExtractResult{
+		Palette:    make([]color.RGBA, o.K),
+		Weights:    make([]float64, o.K),
+		Pixels:     h.pixels,
+		Colors:     len(h.colors),
+		Iterations: km.iterations,
+		Options:    o,
+	}
+	// images with fewer than K distinct colors repeat them, with zero weight
+	for j := range out.Palette {
+		out.Palette[j] = colors[order[j%len(order)]]
+		if j < len(order) {
+			out.Weights[j] = weights[order[j]]
+		}
+	}
+	return out, nil
+}
+
+// ClusterWithOptions clusters palettes of equal length into `o.K` clusters
+func ClusterWithOptions(ctx context.Context, palettes [][]color.RGBA, o Options) (*ClusterResult, error) {
+	o, err := o.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	out := &ClusterResult{Options: o}
+	if len(palettes) == 0 {
+		return out, nil
+	}
+	n := len(palettes[0])
+	points := newPointSet(3*n, len(palettes))
+	for i, p := range palettes {
+		if len(p) != n {
+			return nil, fmt.Errorf("palette %d has %d colors, expected %d", i, len(p), n)
+		}
+		for _, c := range p {
+			points.add3(o.ColorSpace.Point(c))
+		}
+	}
+	km, err := clusterPoints(ctx, points, nil, o, rand.New(rand.NewSource(o.Seed)))
+	if err != nil {
+		return nil, err
+	}
+	out.Labels = km.intLabels()
+	out.Iterations = km.iterations
+	out.Sizes = make([]int, len(km.means))
+	for _, label := range km.labels {
+		out.Sizes[label]++
+	}
+	out.Centroids = make([][]color.RGBA, len(km.means))
+	for j, m := range km.means {
+		out.Centroids[j] = make([]color.RGBA, n)
+		for i := range out.Centroids[j] {
+			out.Centroids[j][i] = o.ColorSpace.Color([3]float64{m[3*i], m[3*i+1], m[3*i+2]})
+		}
+	}
+	return out, nil
+}
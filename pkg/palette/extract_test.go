@@ -0,0 +1,165 @@
+package palette
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+)
+
+// distinctImage returns a deterministic image of random colors, so that nearly every pixel
+// has a distinct color: the worst case for the memory use of extraction
+func distinctImage(size int) *image.RGBA {
+	rng := rand.New(rand.NewSource(int64(size)))
+	i := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := rng.Uint32()
+			i.SetRGBA(x, y, color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255})
+		}
+	}
+	return i
+}
+
+// noiseImage returns a deterministic image of the given size with smooth gradients and noise,
+// which has many distinct colors like a photograph
+func noiseImage(size int) *image.RGBA {
+	rng := rand.New(rand.NewSource(int64(size)))
+	i := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			i.SetRGBA(x, y, color.RGBA{
+				R: uint8(x * 255 / size),
+				G: uint8(y * 255 / size),
+				B: uint8(rng.Intn(64) + (x+y)*64/size),
+				A: 255,
+			})
+		}
+	}
+	return i
+}
+
+// randomPalettes returns `n` deterministic random palettes of `k` colors
+func randomPalettes(n, k int) [][]color.RGBA {
+	rng := rand.New(rand.NewSource(int64(n)))
+	out := make([][]color.RGBA, n)
+	for i := range out {
+		out[i] = make([]color.RGBA, k)
+		for j := range out[i] {
+			out[i][j] = color.RGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255}
+		}
+		Palette(out[i]).Sort(OrderLHS)
+	}
+	return out
+}
+
+func BenchmarkExtract(b *testing.B) {
+	for _, size := range []int{64, 256, 1024} {
+		i := noiseImage(size)
+		suffix := fmt.Sprintf("/%dx%d", size, size)
+		b.Run("Extract"+suffix, func(b *testing.B) {
+			b.ReportAllocs()
+			cache := NewColorCache(1 << 16)
+			for n := 0; n < b.N; n++ {
+				Extract(cache, 8, i)
+			}
+		})
+		b.Run("ExtractWithOptions"+suffix, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				ExtractWithOptions(context.Background(), i, Options{K: 8})
+			}
+		})
+		b.Run("ExtractWithOptions/sample=10000"+suffix, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				ExtractWithOptions(context.Background(), i, Options{K: 8, Sample: 10000})
+			}
+		})
+	}
+}
+
+// peakHeap returns the largest increase of the heap (live and not yet collected objects)
+// over its size before calling `f`, sampled while `f` runs
+func peakHeap(f func()) uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	read := func() uint64 {
+		metrics.Read(sample)
+		return sample[0].Value.Uint64()
+	}
+	runtime.GC()
+	base := read()
+	peak := base
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			if v := read(); v > peak {
+				peak = v
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+		}
+	}()
+	f()
+	close(done)
+	wg.Wait()
+	if v := read(); v > peak {
+		peak = v
+	}
+	return peak - base
+}
+
+// BenchmarkExtractMemory reports the peak heap use of extraction besides the decoded image,
+// in MB per megapixel, for images where nearly every pixel has a distinct color.
+// These are the figures given in the documentation of Extract and ExtractWithOptions.
+func BenchmarkExtractMemory(b *testing.B) {
+	extracts := []struct {
+		name    string
+		extract func(i image.Image) error
+	}{
+		{"Extract", func(i image.Image) error {
+			_, err := Extract(NewColorCache(1<<16), 8, i)
+			return err
+		}},
+		{"ExtractWithOptions", func(i image.Image) error {
+			_, err := ExtractWithOptions(context.Background(), i, Options{K: 8})
+			return err
+		}},
+		{"ExtractWithOptions/sample=100000", func(i image.Image) error {
+			_, err := ExtractWithOptions(context.Background(), i, Options{K: 8, Sample: 100000})
+			return err
+		}},
+	}
+	for _, size := range []int{1024, 2048} {
+		i := distinctImage(size)
+		megapixels := float64(size*size) / 1e6
+		for _, e := range extracts {
+			b.Run(fmt.Sprintf("%s/%dx%d", e.name, size, size), func(b *testing.B) {
+				b.ReportAllocs()
+				var peak uint64
+				for n := 0; n < b.N; n++ {
+					var err error
+					if p := peakHeap(func() { err = e.extract(i) }); p > peak {
+						peak = p
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(peak)/1e6/megapixels, "peak-MB/MP")
+			})
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package palette
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadJSON reads a palette from a JSON object with a `palette` field holding colors (see ParseColor)
+func ReadJSON(r io.Reader) ([]color.RGBA, error) {
+	var obj struct {
+		Palette Palette `json:"palette"`
+	}
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return nil, err
+	}
+	return obj.Palette, nil
+}
+
+// ReadGPL reads a palette in GIMP palette (.gpl) format
+func ReadGPL(r io.Reader) ([]color.RGBA, error) {
+	var out []color.RGBA
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		switch {
+		case line == 1 && text != "GIMP Palette":
+			return nil, fmt.Errorf("missing GIMP Palette header")
+		case line == 1, text == "", strings.HasPrefix(text, "#"),
+			strings.HasPrefix(text, "Name:"), strings.HasPrefix(text, "Columns:"):
+			continue
+		}
+		var r, g, b uint8
+		if _, err := fmt.Sscan(text, &r, &g, &b); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		out = append(out, color.RGBA{R: r, G: g, B: b, A: 255})
+	}
+	return out, s.Err()
+}
+
+// ReadHex reads a palette given as one color per line (see ParseColor)
+func ReadHex(r io.Reader) ([]color.RGBA, error) {
+	var out []color.RGBA
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		c, err := ParseColor(text)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, s.Err()
+}
+
+// ReadFile reads a palette from a JSON (.json), GIMP palette (.gpl) or hex text file
+func ReadFile(path string) ([]color.RGBA, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ReadJSON(f)
+	case ".gpl":
+		return ReadGPL(f)
+	}
+	return ReadHex(f)
+}
+
+// Hex formats a color as `#rrggbb`, or `#rrggbbaa` if it is not opaque
+func Hex(c color.RGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// WriteGPL writes a palette in GIMP palette (.gpl) format
+func WriteGPL(w io.Writer, name string, p []color.RGBA) error {
+	if _, err := fmt.Fprintf(w, "GIMP Palette\nName: %s\nColumns: %d\n#\n", name, len(p)); err != nil {
+		return err
+	}
+	for _, c := range p {
+		if _, err := fmt.Fprintf(w, "%3d %3d %3d\t%s\n", c.R, c.G, c.B, Hex(c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
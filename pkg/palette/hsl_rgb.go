@@ -20,11 +20,16 @@ func hrgb(v1, v2, h float64) float64 {
 	return v1
 }
 
+// channel rounds a component in [0, 1] to the nearest 8-bit value
+func channel(v float64) uint8 {
+	return uint8(math.Round(255 * math.Max(0, math.Min(1, v))))
+}
+
 func rgb(h, s, l float64) (r, g, b uint8) {
 	if s == 0 {
-		r = uint8(255 * l)
-		g = uint8(255 * l)
-		b = uint8(255 * l)
+		r = channel(l)
+		g = channel(l)
+		b = channel(l)
 		return
 	}
 
@@ -37,9 +42,9 @@ func rgb(h, s, l float64) (r, g, b uint8) {
 
 	v1 = 2*l - v2
 
-	r = uint8(255 * hrgb(v1, v2, h+(1.0/3.0)))
-	g = uint8(255 * hrgb(v1, v2, h))
-	b = uint8(255 * hrgb(v1, v2, h-(1.0/3.0)))
+	r = channel(hrgb(v1, v2, h+(1.0/3.0)))
+	g = channel(hrgb(v1, v2, h))
+	b = channel(hrgb(v1, v2, h-(1.0/3.0)))
 
 	return
 }
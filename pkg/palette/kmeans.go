@@ -0,0 +1,300 @@
+package palette
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// pointSet is a set of `dim`-dimensional points stored contiguously,
+// using 4 bytes per coordinate and no per-point allocation
+type pointSet struct {
+	dim  int
+	data []float32
+}
+
+func newPointSet(dim, capacity int) *pointSet {
+	return &pointSet{dim: dim, data: make([]float32, 0, dim*capacity)}
+}
+
+func (p *pointSet) len() int {
+	if p.dim == 0 {
+		return 0
+	}
+	return len(p.data) / p.dim
+}
+
+// at returns the j-th point, sharing storage with the set
+func (p *pointSet) at(j int) []float32 {
+	return p.data[j*p.dim : (j+1)*p.dim : (j+1)*p.dim]
+}
+
+func (p *pointSet) add3(q [3]float64) {
+	p.data = append(p.data, float32(q[0]), float32(q[1]), float32(q[2]))
+}
+
+func (p *pointSet) add(q []float64) {
+	for _, x := range q {
+		p.data = append(p.data, float32(x))
+	}
+}
+
+func (p *pointSet) mean(j int) []float64 {
+	out := make([]float64, p.dim)
+	for d, x := range p.at(j) {
+		out[d] = float64(x)
+	}
+	return out
+}
+
+func pointDistance(p []float32, m []float64) (d float64) {
+	for i := range p {
+		x := float64(p[i]) - m[i]
+		d += x * x
+	}
+	return
+}
+
+// kmeansResult is the outcome of clustering weighted points
+type kmeansResult struct {
+	means      [][]float64
+	labels     []int32
+	iterations int
+}
+
+func (r kmeansResult) intLabels() []int {
+	out := make([]int, len(r.labels))
+	for j, label := range r.labels {
+		out[j] = int(label)
+	}
+	return out
+}
+
+// kmeansChunkSize is the number of points per unit of parallel work. Partial sums are
+// merged in chunk order, so results do not depend on the number of workers.
+const kmeansChunkSize = 4096
+
+// forEachChunk calls `f` for each chunk of `n` points, using up to `workers` goroutines
+func forEachChunk(n, workers int, f func(chunk, start, end int)) {
+	chunks := (n + kmeansChunkSize - 1) / kmeansChunkSize
+	if workers > chunks {
+		workers = chunks
+	}
+	run := func(chunk int) {
+		start := chunk * kmeansChunkSize
+		end := start + kmeansChunkSize
+		if end > n {
+			end = n
+		}
+		f(chunk, start, end)
+	}
+	if workers <= 1 {
+		for chunk := 0; chunk < chunks; chunk++ {
+			run(chunk)
+		}
+		return
+	}
+	var next int64 = -1
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for chunk := int(atomic.AddInt64(&next, 1)); chunk < chunks; chunk = int(atomic.AddInt64(&next, 1)) {
+				run(chunk)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// kmeansPartial holds the per-chunk results of an assignment step
+type kmeansPartial struct {
+	sums    [][]float64
+	totals  []float64
+	changed bool
+}
+
+// weightOf returns the weight of the j-th point; nil weights weigh every point 1
+func weightOf(weights []float64, j int) float64 {
+	if weights == nil {
+		return 1
+	}
+	return weights[j]
+}
+
+// clusterPoints clusters weighted points into at most `o.K` clusters using Lloyd's algorithm,
+// seeded according to `o.Algorithm`. A `o.MaxIterations` of 0 runs until convergence.
+// The assignment and update steps are split over `o.Workers` goroutines.
+// Besides the points themselves, clustering needs 8 bytes per point (a label and a seeding distance).
+func clusterPoints(ctx context.Context, ps *pointSet, weights []float64, o Options, rng *rand.Rand) (kmeansResult, error) {
+	k, n := o.K, ps.len()
+	if n <= k {
+		out := kmeansResult{means: make([][]float64, n), labels: make([]int32, n)}
+		for j := range out.means {
+			out.means[j] = ps.mean(j)
+			out.labels[j] = int32(j)
+		}
+		return out, nil
+	}
+	workers := o.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var means [][]float64
+	switch o.Algorithm {
+	case AlgorithmKmeansFarthest:
+		means = seedFarthest(ps, weights, k, workers)
+	default:
+		means = seedPlusPlus(ps, weights, k, rng, workers)
+	}
+
+	out := kmeansResult{means: means, labels: make([]int32, n)}
+	for j := range out.labels {
+		out.labels[j] = -1
+	}
+	partials := make([]kmeansPartial, (n+kmeansChunkSize-1)/kmeansChunkSize)
+	for i := range partials {
+		partials[i].sums = make([][]float64, k)
+		for c := range partials[i].sums {
+			partials[i].sums[c] = make([]float64, ps.dim)
+		}
+		partials[i].totals = make([]float64, k)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return out, err
+		}
+		// assign each point to its nearest mean, summing the points of each cluster per chunk
+		forEachChunk(n, workers, func(chunk, start, end int) {
+			partial := &partials[chunk]
+			partial.changed = false
+			for c := range partial.sums {
+				for d := range partial.sums[c] {
+					partial.sums[c][d] = 0
+				}
+				partial.totals[c] = 0
+			}
+			for j := start; j < end; j++ {
+				p := ps.at(j)
+				label, min := 0, math.Inf(1)
+				for c, m := range means {
+					if d := pointDistance(p, m); d < min {
+						label, min = c, d
+					}
+				}
+				if int32(label) != out.labels[j] {
+					out.labels[j] = int32(label)
+					partial.changed = true
+				}
+				w := weightOf(weights, j)
+				sum := partial.sums[label]
+				for d := range p {
+					sum[d] += float64(p[d]) * w
+				}
+				partial.totals[label] += w
+			}
+		})
+		changed := false
+		for i := range partials {
+			changed = changed || partials[i].changed
+		}
+		if !changed || (o.MaxIterations > 0 && out.iterations >= o.MaxIterations) {
+			return out, nil
+		}
+		out.iterations++
+		// move each mean to the weighted mean of its cluster
+		for c := range means {
+			total := 0.0
+			for i := range partials {
+				total += partials[i].totals[c]
+			}
+			if total == 0 {
+				continue
+			}
+			for d := range means[c] {
+				sum := 0.0
+				for i := range partials {
+					sum += partials[i].sums[c][d]
+				}
+				means[c][d] = sum / total
+			}
+		}
+	}
+}
+
+// updateNearest lowers each point's squared distance to its nearest seed to account for a new seed
+func updateNearest(ps *pointSet, nearest []float32, seed []float64, workers int) {
+	forEachChunk(len(nearest), workers, func(_, start, end int) {
+		for j := start; j < end; j++ {
+			if d := float32(pointDistance(ps.at(j), seed)); d < nearest[j] {
+				nearest[j] = d
+			}
+		}
+	})
+}
+
+func newNearest(ps *pointSet, seed []float64, workers int) []float32 {
+	nearest := make([]float32, ps.len())
+	for j := range nearest {
+		nearest[j] = float32(math.Inf(1))
+	}
+	updateNearest(ps, nearest, seed, workers)
+	return nearest
+}
+
+// seedFarthest chooses seeds deterministically: the heaviest point, then repeatedly the point
+// with the largest weighted squared distance to its nearest seed.
+func seedFarthest(ps *pointSet, weights []float64, k int, workers int) [][]float64 {
+	heaviest := 0
+	for j, w := range weights {
+		if w > weights[heaviest] {
+			heaviest = j
+		}
+	}
+	means := [][]float64{ps.mean(heaviest)}
+	nearest := newNearest(ps, means[0], workers)
+	for len(means) < k {
+		next, best := 0, -1.0
+		for j := range nearest {
+			if s := float64(nearest[j]) * weightOf(weights, j); s > best {
+				next, best = j, s
+			}
+		}
+		means = append(means, ps.mean(next))
+		updateNearest(ps, nearest, means[len(means)-1], workers)
+	}
+	return means
+}
+
+// seedPlusPlus chooses seeds by k-means++: the first at random by weight, then each next one
+// with probability proportional to its weighted squared distance to the nearest seed.
+func seedPlusPlus(ps *pointSet, weights []float64, k int, rng *rand.Rand, workers int) [][]float64 {
+	n := ps.len()
+	pick := func(score func(j int) float64) int {
+		total := 0.0
+		for j := 0; j < n; j++ {
+			total += score(j)
+		}
+		target := rng.Float64() * total
+		for j := 0; j < n; j++ {
+			target -= score(j)
+			if target < 0 {
+				return j
+			}
+		}
+		return n - 1
+	}
+	first := pick(func(j int) float64 { return weightOf(weights, j) })
+	means := [][]float64{ps.mean(first)}
+	nearest := newNearest(ps, means[0], workers)
+	for len(means) < k {
+		next := pick(func(j int) float64 { return float64(nearest[j]) * weightOf(weights, j) })
+		means = append(means, ps.mean(next))
+		updateNearest(ps, nearest, means[len(means)-1], workers)
+	}
+	return means
+}
@@ -0,0 +1,58 @@
+package palette
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomPoints returns `n` deterministic random weighted points in three dimensions
+func randomPoints(n int) (*pointSet, []float64) {
+	rng := rand.New(rand.NewSource(int64(n)))
+	ps := newPointSet(3, n)
+	weights := make([]float64, n)
+	for j := range weights {
+		ps.add3([3]float64{rng.Float64() * 100, rng.Float64() * 100, rng.Float64() * 100})
+		weights[j] = float64(1 + rng.Intn(10))
+	}
+	return ps, weights
+}
+
+// TestClusterPointsWorkers checks that the result of clustering does not depend on the number of workers
+func TestClusterPointsWorkers(t *testing.T) {
+	sizes := []int{
+		kmeansChunkSize / 4,     // a single partial chunk
+		kmeansChunkSize * 3,     // whole chunks only
+		kmeansChunkSize*3 + 123, // a partial last chunk
+	}
+	for _, n := range sizes {
+		ps, weights := randomPoints(n)
+		for _, algorithm := range Algorithms {
+			t.Run(fmt.Sprintf("n=%d/%s", n, algorithm), func(t *testing.T) {
+				var want kmeansResult
+				for _, workers := range []int{1, 3, 8} {
+					o := Options{K: 8, Algorithm: algorithm, Workers: workers}
+					got, err := clusterPoints(context.Background(), ps, weights, o, rand.New(rand.NewSource(1)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if workers == 1 {
+						want = got
+						continue
+					}
+					if !reflect.DeepEqual(got.means, want.means) {
+						t.Errorf("workers=%d: means %v, want %v as with one worker", workers, got.means, want.means)
+					}
+					if !reflect.DeepEqual(got.labels, want.labels) {
+						t.Errorf("workers=%d: labels differ from those with one worker", workers)
+					}
+					if got.iterations != want.iterations {
+						t.Errorf("workers=%d: %d iterations, want %d as with one worker", workers, got.iterations, want.iterations)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package palette
+
+import "math"
+
+func linear(c uint8) float64 {
+	v := float64(c) / 255.0
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389.0 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27.0*t + 16) / 116
+}
+
+// lab converts an sRGB color to CIE L*a*b* (D65 white point)
+func lab(rb, gb, bb uint8) (l, a, b float64) {
+	r, g, bl := linear(rb), linear(gb), linear(bb)
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*bl) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*bl
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*bl) / 1.08883
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	l = 116*fy - 16
+	a = 500 * (fx - fy)
+	b = 200 * (fy - fz)
+	return
+}
+
+func labFInverse(t float64) float64 {
+	if t3 := t * t * t; t3 > 216.0/24389.0 {
+		return t3
+	}
+	return (116*t - 16) * 27.0 / 24389.0
+}
+
+func encode(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(255 * math.Max(0, math.Min(1, v))))
+}
+
+// labRGB converts a CIE L*a*b* color (D65 white point) to sRGB, clamping it to the sRGB gamut
+func labRGB(l, a, b float64) (rb, gb, bb uint8) {
+	fy := (l + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	x, y, z := labFInverse(fx)*0.95047, labFInverse(fy), labFInverse(fz)*1.08883
+	rb = encode(3.2404542*x - 1.5371385*y - 0.4985314*z)
+	gb = encode(-0.9692660*x + 1.8760108*y + 0.0415560*z)
+	bb = encode(0.0556434*x - 0.2040259*y + 1.0572252*z)
+	return
+}
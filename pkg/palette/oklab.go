@@ -0,0 +1,37 @@
+package palette
+
+import "math"
+
+// oklch converts an sRGB color to OKLCh: lightness in [0,1], chroma, and hue in degrees
+func oklch(rb, gb, bb uint8) (l, c, h float64) {
+	r, g, b := linear(rb), linear(gb), linear(bb)
+	lc := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	mc := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	sc := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	l = 0.2104542553*lc + 0.7936177850*mc - 0.0040720468*sc
+	a := 1.9779984951*lc - 2.4285922050*mc + 0.4505937099*sc
+	bo := 0.0259040371*lc + 0.7827717662*mc - 0.8086757660*sc
+	c = math.Hypot(a, bo)
+	if c < 1e-4 {
+		// the hue of grays is meaningless
+		return l, 0, 0
+	}
+	h = math.Atan2(bo, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return
+}
+
+// oklchRGB converts an OKLCh color to sRGB, clamping it to the sRGB gamut
+func oklchRGB(l, c, h float64) (rb, gb, bb uint8) {
+	a, b := c*math.Cos(h*math.Pi/180), c*math.Sin(h*math.Pi/180)
+	lc := l + 0.3963377774*a + 0.2158037573*b
+	mc := l - 0.1055613458*a - 0.0638541728*b
+	sc := l - 0.0894841775*a - 1.2914855480*b
+	lc, mc, sc = lc*lc*lc, mc*mc*mc, sc*sc*sc
+	rb = encode(4.0767416621*lc - 3.3077115913*mc + 0.2309699292*sc)
+	gb = encode(-1.2684380046*lc + 2.6097574011*mc - 0.3413193965*sc)
+	bb = encode(-0.0041960863*lc - 0.7034186147*mc + 1.7076147010*sc)
+	return
+}
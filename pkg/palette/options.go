@@ -0,0 +1,69 @@
+package palette
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Clustering algorithms accepted by Options.Algorithm
+const (
+	// AlgorithmKmeansPP is k-means with randomized k-means++ seeding
+	AlgorithmKmeansPP = "kmeans++"
+	// AlgorithmKmeansFarthest is k-means with deterministic farthest-point seeding
+	AlgorithmKmeansFarthest = "kmeans-farthest"
+)
+
+// Algorithms lists the names of all clustering algorithms
+var Algorithms = []string{AlgorithmKmeansPP, AlgorithmKmeansFarthest}
+
+// Options configure ExtractWithOptions, ExtractFramesWithOptions and ClusterWithOptions.
+// The zero value of each field except K selects its default.
+type Options struct {
+	// K is the number of palette colors to extract, or the number of clusters to make
+	K int
+	// Algorithm is the clustering algorithm, one of Algorithms (default AlgorithmKmeansPP)
+	Algorithm string
+	// ColorSpace is the color space in which colors are clustered (default ColorSpaceRGB)
+	ColorSpace ColorSpace
+	// Sample is the maximum number of randomly chosen pixels to use per image (0 for all pixels)
+	Sample int
+	// Seed seeds the random choices of sampling and k-means++ seeding
+	Seed int64
+	// MaxIterations limits the number of k-means iterations (0 to run until convergence)
+	MaxIterations int
+	// Workers is the number of goroutines used to cluster a single image (0 for one per CPU).
+	// It does not affect the result.
+	Workers int
+}
+
+func (o Options) withDefaults() (Options, error) {
+	if o.K <= 0 {
+		return o, errors.New("k must be positive")
+	}
+	if o.Algorithm == "" {
+		o.Algorithm = AlgorithmKmeansPP
+	}
+	known := false
+	for _, a := range Algorithms {
+		known = known || a == o.Algorithm
+	}
+	if !known {
+		return o, fmt.Errorf("unknown algorithm %q", o.Algorithm)
+	}
+	if int(o.ColorSpace) < 0 || int(o.ColorSpace) >= len(ColorSpaces) {
+		return o, fmt.Errorf("unknown color space %v", o.ColorSpace)
+	}
+	return o, nil
+}
+
+// Validate checks that the options are valid
+func (o Options) Validate() error {
+	_, err := o.withDefaults()
+	return err
+}
+
+// Key returns a string identifying the options, for use in cache keys
+func (o Options) Key() string {
+	o, _ = o.withDefaults()
+	return fmt.Sprintf("%s/%s/k=%d/sample=%d/seed=%d/iterations=%d", o.Algorithm, o.ColorSpace, o.K, o.Sample, o.Seed, o.MaxIterations)
+}
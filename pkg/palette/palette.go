@@ -1,27 +1,24 @@
 package palette
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/color"
-	"math"
+	"math/rand"
 	"sort"
-
-	"github.com/bugra/kmeans"
 )
 
-func imagePoints(cache *ColorCache, i image.Image) (out [][]float64) {
+// imagePoints appends the HSL points of all pixels of an image to `out`
+func imagePoints(cache *ColorCache, i image.Image, out *pointSet) {
 	size := i.Bounds().Size()
-	out = make([][]float64, size.X*size.Y)
-	j := 0
 	for x := 0; x < size.X; x++ {
 		for y := 0; y < size.Y; y++ {
 			c := i.At(x, y)
 			r, g, b, _ := c.RGBA()
-			out[j] = cache.Get(r, g, b)
-			j++
+			out.add3(cache.Point(r, g, b))
 		}
 	}
-	return
 }
 
 func Render(palette []color.RGBA, size int) image.Image {
@@ -47,6 +44,33 @@ func Render(palette []color.RGBA, size int) image.Image {
 	return i
 }
 
+// RenderTimeline renders palettes as an image with one row of `size`x`size` color squares per palette
+func RenderTimeline(palettes [][]color.RGBA, size int) image.Image {
+	width := 0
+	for _, p := range palettes {
+		if len(p) > width {
+			width = len(p)
+		}
+	}
+	i := image.NewRGBA(image.Rectangle{
+		Max: image.Point{
+			X: size * width,
+			Y: size * len(palettes),
+		},
+	})
+	for row, p := range palettes {
+		for j, c := range p {
+			c.A = 255
+			for x := j * size; x < (j+1)*size; x++ {
+				for y := row * size; y < (row+1)*size; y++ {
+					i.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return i
+}
+
 // Cluster clusters palettes
 func Cluster(cache *PaletteCache, k int, ps [][]color.RGBA) ([]int, [][]color.RGBA, error) {
 	if len(ps) == 0 {
@@ -54,70 +78,63 @@ func Cluster(cache *PaletteCache, k int, ps [][]color.RGBA) ([]int, [][]color.RG
 	}
 	n := len(ps[0])
 
-	points := make([][]float64, len(ps))
+	points := newPointSet(3*n, len(ps))
 	for i := range ps {
-		points[i] = cache.Get(ps[i])
+		points.add(cache.Get(ps[i]))
 	}
 
-	labels, err := kmeans.Kmeans(points, k, kmeans.EuclideanDistance, int(math.MaxInt32))
+	result, err := uniformKmeans(points, k)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	centroidPoints := make([]kmeans.Observation, k)
-	centroidPointCount := make([]uint64, k)
-	for i, label := range labels {
-		if centroidPoints[label] == nil {
-			centroidPoints[label] = make(kmeans.Observation, n*3)
-		}
-		centroidPoints[label].Add(kmeans.Observation(points[i]))
-		centroidPointCount[label]++
-	}
-
 	centroid := make([][]color.RGBA, k)
-	for j, point := range centroidPoints {
-		count := float64(centroidPointCount[j])
+	for j := range centroid {
+		point := result.means[j%len(result.means)]
 		centroid[j] = make([]color.RGBA, n)
 		for i := 0; i < n; i++ {
-			h := point[0+3*i] / count / weightH
-			s := point[1+3*i] / count / weightS
-			l := point[2+3*i] / count / weightL
+			h := point[0+3*i] / weightH
+			s := point[1+3*i] / weightS
+			l := point[2+3*i] / weightL
 			r, g, b := rgb(h, s, l)
 			centroid[j][i] = color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}
 		}
 	}
 
-	return labels, centroid, nil
+	return result.intLabels(), centroid, nil
 }
 
-// Extract extracts a `k`-color palette from an image
+// Extract extracts a `k`-color palette from an image.
+// The color tables of paletted images (GIF, 8-bit PNG) are clustered directly, weighted by pixel count.
+// Other images are clustered pixel by pixel, using about 25 MB per megapixel besides the decoded image.
 func Extract(cache *ColorCache, k int, i image.Image) ([]color.RGBA, error) {
-	points := imagePoints(cache, i)
-	labels, err := kmeans.Kmeans(points, k, kmeans.EuclideanDistance, int(math.MaxInt32))
-	if err != nil {
-		return nil, err
+	if pi, ok := i.(*image.Paletted); ok {
+		return extractPaletted(cache, k, pi)
 	}
+	size := i.Bounds().Size()
+	points := newPointSet(3, size.X*size.Y)
+	imagePoints(cache, i, points)
+	return extractPoints(k, points)
+}
 
-	centroidPoints := make([]kmeans.Observation, k)
-	for label := range centroidPoints {
-		centroidPoints[label] = make(kmeans.Observation, 3)
+// uniformKmeans clusters equally weighted points into at most `k` clusters with k-means++ seeding
+func uniformKmeans(points *pointSet, k int) (kmeansResult, error) {
+	if points.len() == 0 {
+		return kmeansResult{}, errors.New("no points to cluster")
 	}
-	centroidPointCount := make([]uint64, k)
-	for j, label := range labels {
-		centroidPoints[label].Add(kmeans.Observation(points[j]))
-		centroidPointCount[label]++
+	return clusterPoints(context.Background(), points, nil, Options{K: k}, rand.New(rand.NewSource(0)))
+}
+
+func extractPoints(k int, points *pointSet) ([]color.RGBA, error) {
+	result, err := uniformKmeans(points, k)
+	if err != nil {
+		return nil, err
 	}
 
 	centroid := make([]color.RGBA, k)
-	for j, point := range centroidPoints {
-		n := float64(centroidPointCount[j])
-		h := point[0] / n
-		s := point[1] / n
-		l := point[2] / n
-		r, g, b := rgb(h, s, l)
-		// r := uint32(point[0] / n * 255.0)
-		// g := uint32(point[1] / n * 255.0)
-		// b := uint32(point[2] / n * 255.0)
+	for j := range centroid {
+		point := result.means[j%len(result.means)]
+		r, g, b := rgb(point[0], point[1], point[2])
 		centroid[j] = color.RGBA{R: uint8(r), G: uint8(g), B: uint8(b), A: 255}
 	}
 
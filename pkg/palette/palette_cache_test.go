@@ -0,0 +1,13 @@
+package palette
+
+import "testing"
+
+func BenchmarkPaletteCache(b *testing.B) {
+	ps := randomPalettes(1000, 8)
+	cache := NewPaletteCache(len(ps))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		cache.Get(ps[n%len(ps)])
+	}
+}
@@ -0,0 +1,35 @@
+package palette
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func BenchmarkCluster(b *testing.B) {
+	for _, count := range []int{100, 1000, 10000} {
+		ps := randomPalettes(count, 8)
+		suffix := fmt.Sprintf("/palettes=%d", count)
+		b.Run("Cluster"+suffix, func(b *testing.B) {
+			b.ReportAllocs()
+			cache := NewPaletteCache(count)
+			for n := 0; n < b.N; n++ {
+				Cluster(cache, 5, ps)
+			}
+		})
+		b.Run("ClusterWithOptions"+suffix, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				ClusterWithOptions(context.Background(), ps, Options{K: 5})
+			}
+		})
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	p := randomPalettes(1, 8)[0]
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		Render(p, 100)
+	}
+}
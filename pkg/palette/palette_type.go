@@ -0,0 +1,139 @@
+package palette
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"sort"
+	"strings"
+)
+
+// Palette is a list of colors.
+//
+// It marshals to JSON as an array of hex colors and to text as space-separated hex colors,
+// and unmarshals from any color syntax accepted by ParseColor.
+// As a sort.Interface it orders colors by OrderLHS; use By for other orderings.
+type Palette []color.RGBA
+
+func (p Palette) Len() int           { return len(p) }
+func (p Palette) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p Palette) Less(i, j int) bool { return OrderLHS(p[i], p[j]) }
+
+type byOrder struct {
+	Palette
+	order Order
+}
+
+func (p byOrder) Less(i, j int) bool { return p.order(p.Palette[i], p.Palette[j]) }
+
+// By returns a sort.Interface ordering the palette's colors by `order`
+func (p Palette) By(order Order) sort.Interface {
+	return byOrder{p, order}
+}
+
+// Sort sorts the palette's colors in place by `order`
+func (p Palette) Sort(order Order) {
+	sort.Sort(p.By(order))
+}
+
+func (p Palette) format(f func(color.RGBA) string) []string {
+	out := make([]string, len(p))
+	for i, c := range p {
+		out[i] = f(c)
+	}
+	return out
+}
+
+// Hex returns the palette's colors as hex strings (see Hex)
+func (p Palette) Hex() []string { return p.format(Hex) }
+
+// RGB returns the palette's colors as CSS `rgb()` functions
+func (p Palette) RGB() []string { return p.format(FormatRGB) }
+
+// HSL returns the palette's colors as CSS `hsl()` functions
+func (p Palette) HSL() []string { return p.format(FormatHSL) }
+
+// OKLCH returns the palette's colors as CSS `oklch()` functions
+func (p Palette) OKLCH() []string { return p.format(FormatOKLCH) }
+
+// MarshalJSON implements json.Marshaler
+func (p Palette) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Hex())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *Palette) UnmarshalJSON(data []byte) error {
+	var colors []string
+	if err := json.Unmarshal(data, &colors); err != nil {
+		return err
+	}
+	out := make(Palette, len(colors))
+	for i, s := range colors {
+		c, err := ParseColor(s)
+		if err != nil {
+			return fmt.Errorf("palette color %d: %v", i, err)
+		}
+		out[i] = c
+	}
+	*p = out
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (p Palette) MarshalText() ([]byte, error) {
+	return []byte(strings.Join(p.Hex(), " ")), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. Colors are separated by commas or whitespace outside of parentheses.
+func (p *Palette) UnmarshalText(text []byte) error {
+	var out Palette
+	for _, s := range splitColors(string(text)) {
+		c, err := ParseColor(s)
+		if err != nil {
+			return err
+		}
+		out = append(out, c)
+	}
+	*p = out
+	return nil
+}
+
+// splitColors splits a list of colors at commas and whitespace outside of parentheses,
+// keeping the space-separated arguments of a function together with its name
+func splitColors(s string) (out []string) {
+	var current strings.Builder
+	depth := 0
+	flush := func() {
+		if current.Len() > 0 {
+			out = append(out, current.String())
+			current.Reset()
+		}
+	}
+	for i, r := range s {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case depth == 0 && (r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			// a function name may be followed by whitespace before its parenthesis
+			if rest := strings.TrimLeft(s[i:], " \t"); strings.HasPrefix(rest, "(") {
+				continue
+			}
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	flush()
+	return out
+}
+
+// Palettes converts a list of color lists to a list of palettes
+func Palettes(ps [][]color.RGBA) []Palette {
+	out := make([]Palette, len(ps))
+	for i, p := range ps {
+		out[i] = p
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package palette
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Embedded returns the color table of a paletted image
+func Embedded(i image.Image) ([]color.RGBA, bool) {
+	pi, ok := i.(*image.Paletted)
+	if !ok {
+		return nil, false
+	}
+	out := make([]color.RGBA, len(pi.Palette))
+	for j, c := range pi.Palette {
+		out[j] = color.RGBAModel.Convert(c).(color.RGBA)
+	}
+	return out, true
+}
+
+// extractPaletted extracts a `k`-color palette from a paletted image by clustering
+// its color table, weighting each color by the number of pixels using it
+func extractPaletted(cache *ColorCache, k int, i *image.Paletted) ([]color.RGBA, error) {
+	counts := make([]float64, len(i.Palette))
+	bounds := i.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := i.Pix[i.PixOffset(bounds.Min.X, y):i.PixOffset(bounds.Max.X, y)]
+		for _, j := range row {
+			if int(j) < len(counts) {
+				counts[j]++
+			}
+		}
+	}
+	points := newPointSet(3, len(i.Palette))
+	var weights []float64
+	for j, c := range i.Palette {
+		if counts[j] == 0 {
+			continue
+		}
+		r, g, b, _ := c.RGBA()
+		points.add3(cache.Point(r, g, b))
+		weights = append(weights, counts[j])
+	}
+	if points.len() == 0 {
+		return nil, errors.New("empty image")
+	}
+	centroids := weightedKmeans(points, weights, k)
+	out := make([]color.RGBA, k)
+	for j := range out {
+		out[j] = hslColor(centroids[j%len(centroids)])
+	}
+	sort.Slice(out, func(i int, j int) bool { return LessLHS(out, i, j) })
+	return out, nil
+}
+
+func hslColor(point []float64) color.RGBA {
+	r, g, b := rgb(point[0], point[1], point[2])
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+// weightedKmeans clusters weighted points into at most `k` clusters and returns their weighted means, using deterministic farthest-point seeding
+func weightedKmeans(points *pointSet, weights []float64, k int) [][]float64 {
+	result, _ := clusterPoints(context.Background(), points, weights, Options{K: k, Algorithm: AlgorithmKmeansFarthest}, nil)
+	return result.means
+}
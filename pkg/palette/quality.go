@@ -0,0 +1,56 @@
+package palette
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Fidelity measures how well a palette represents the colors of an image
+type Fidelity struct {
+	// Mean is the mean CIE76 color difference, ΔE (Euclidean distance in L*a*b*),
+	// between each pixel and its nearest palette color
+	Mean float64
+	// Max is the largest such difference
+	Max float64
+	// Pixels is the number of pixels measured
+	Pixels int
+}
+
+// MeasureFidelity compares every pixel of an image with its nearest palette color in L*a*b*.
+// Lower values mean a more faithful palette; a ΔE of about 2.3 is a just-noticeable difference.
+func MeasureFidelity(i image.Image, p []color.RGBA) (Fidelity, error) {
+	if len(p) == 0 {
+		return Fidelity{}, errors.New("empty palette")
+	}
+	swatches := make([][3]float64, len(p))
+	for j, c := range p {
+		swatches[j] = ColorSpaceLab.Point(c)
+	}
+	h := &histogram{}
+	if err := h.addImage(context.Background(), i, 0, nil); err != nil {
+		return Fidelity{}, err
+	}
+	h.compact()
+	if h.pixels == 0 {
+		return Fidelity{}, errors.New("empty image")
+	}
+	var f Fidelity
+	total := 0.0
+	for j, c := range h.colors {
+		q := ColorSpaceLab.Point(c)
+		min := math.Inf(1)
+		for _, s := range swatches {
+			d := (q[0]-s[0])*(q[0]-s[0]) + (q[1]-s[1])*(q[1]-s[1]) + (q[2]-s[2])*(q[2]-s[2])
+			min = math.Min(min, d)
+		}
+		d := math.Sqrt(min)
+		total += d * h.counts[j]
+		f.Max = math.Max(f.Max, d)
+	}
+	f.Pixels = h.pixels
+	f.Mean = total / float64(h.pixels)
+	return f, nil
+}
@@ -0,0 +1,52 @@
+package palette
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestMeasureFidelity(t *testing.T) {
+	i := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	black, white := color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}
+	i.SetRGBA(0, 0, black)
+	i.SetRGBA(1, 0, black)
+	i.SetRGBA(2, 0, black)
+	i.SetRGBA(3, 0, white)
+	tests := []struct {
+		palette   []color.RGBA
+		mean, max float64
+	}{
+		{[]color.RGBA{black, white}, 0, 0},
+		// white is 100 L* units away from black
+		{[]color.RGBA{black}, 25, 100},
+		{[]color.RGBA{white}, 75, 100},
+	}
+	for _, tt := range tests {
+		f, err := MeasureFidelity(i, tt.palette)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(f.Mean-tt.mean) > 1e-3 || math.Abs(f.Max-tt.max) > 1e-3 || f.Pixels != 4 {
+			t.Errorf("MeasureFidelity(%v) = %+v, want mean %v, max %v, 4 pixels", tt.palette, f, tt.mean, tt.max)
+		}
+	}
+	if _, err := MeasureFidelity(i, nil); err == nil {
+		t.Error("MeasureFidelity with an empty palette succeeded")
+	}
+}
+
+func BenchmarkMeasureFidelity(b *testing.B) {
+	p := randomPalettes(1, 8)[0]
+	for _, size := range []int{64, 256, 1024} {
+		i := noiseImage(size)
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				MeasureFidelity(i, p)
+			}
+		})
+	}
+}
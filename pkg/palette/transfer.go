@@ -0,0 +1,113 @@
+package palette
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// MatchByLightness matches each color of `source` to a color of `target` of the same lightness rank
+func MatchByLightness(source, target []color.RGBA) []int {
+	rank := func(p []color.RGBA) []int {
+		idx := make([]int, len(p))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.SliceStable(idx, func(i, j int) bool {
+			_, _, li := hsl(p[idx[i]].R, p[idx[i]].G, p[idx[i]].B)
+			_, _, lj := hsl(p[idx[j]].R, p[idx[j]].G, p[idx[j]].B)
+			return li < lj
+		})
+		return idx
+	}
+	sourceRank, targetRank := rank(source), rank(target)
+	out := make([]int, len(source))
+	for r, i := range sourceRank {
+		t := 0
+		if len(source) > 1 {
+			t = int(math.Round(float64(r) * float64(len(target)-1) / float64(len(source)-1)))
+		}
+		out[i] = targetRank[t]
+	}
+	return out
+}
+
+// Transfer restyles an image whose palette is `source` with the colors of `target`.
+// Each source color is matched to a target color by lightness rank, and each pixel is shifted
+// by the differences of these matches, weighted by the inverse distance of the pixel to the
+// source colors in the color space `s`. Higher `sharpness` approaches a hard nearest-swatch assignment.
+// Colors are shifted un-premultiplied, and the alpha of each pixel is kept.
+func Transfer(source, target []color.RGBA, i image.Image, s ColorSpace, sharpness float64) (*image.NRGBA, error) {
+	if len(source) == 0 || len(target) == 0 {
+		return nil, errors.New("empty palette")
+	}
+	match := MatchByLightness(source, target)
+	points := make([][3]float64, len(source))
+	shifts := make([][3]float64, len(source))
+	for j, c := range source {
+		points[j] = s.Point(c)
+		t := target[match[j]]
+		shifts[j] = [3]float64{
+			float64(t.R) - float64(c.R),
+			float64(t.G) - float64(c.G),
+			float64(t.B) - float64(c.B),
+		}
+	}
+
+	weights := make([]float64, len(source))
+	shifted := make(map[uint32]color.RGBA)
+	// shift returns the shifted color of the opaque color `c`
+	shift := func(c color.RGBA) color.RGBA {
+		key := uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+		if out, ok := shifted[key]; ok {
+			return out
+		}
+		q := s.Point(c)
+		var total float64
+		exact := -1
+		for j, p := range points {
+			d0, d1, d2 := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+			d := d0*d0 + d1*d1 + d2*d2
+			if d == 0 {
+				exact = j
+				break
+			}
+			weights[j] = math.Pow(d, -sharpness)
+			total += weights[j]
+		}
+		var v [3]float64
+		for k := range v {
+			if exact >= 0 {
+				v[k] = shifts[exact][k]
+				continue
+			}
+			for j := range weights {
+				v[k] += weights[j] / total * shifts[j][k]
+			}
+		}
+		out := color.RGBA{
+			R: clamp8(float64(c.R) + v[0]),
+			G: clamp8(float64(c.G) + v[1]),
+			B: clamp8(float64(c.B) + v[2]),
+			A: 255,
+		}
+		shifted[key] = out
+		return out
+	}
+
+	bounds := i.Bounds()
+	out := image.NewNRGBA(image.Rectangle{Max: bounds.Size()})
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(i.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				continue
+			}
+			shifted := shift(color.RGBA{R: c.R, G: c.G, B: c.B, A: 255})
+			out.SetNRGBA(x-bounds.Min.X, y-bounds.Min.Y, color.NRGBA{R: shifted.R, G: shifted.G, B: shifted.B, A: c.A})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,106 @@
+package palette
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+var (
+	black = color.RGBA{0, 0, 0, 255}
+	white = color.RGBA{255, 255, 255, 255}
+	red   = color.RGBA{200, 0, 0, 255}
+	navy  = color.RGBA{0, 0, 90, 255}
+	sky   = color.RGBA{120, 180, 255, 255}
+	cream = color.RGBA{255, 250, 220, 255}
+)
+
+func TestMatchByLightness(t *testing.T) {
+	tests := []struct {
+		name           string
+		source, target []color.RGBA
+		want           []int
+	}{
+		{"same size", []color.RGBA{white, black, red}, []color.RGBA{sky, cream, navy}, []int{1, 2, 0}},
+		{"fewer targets", []color.RGBA{black, red, white}, []color.RGBA{cream, navy}, []int{1, 0, 0}},
+		{"more targets", []color.RGBA{white, black}, []color.RGBA{sky, navy, cream}, []int{2, 1}},
+		{"one source", []color.RGBA{red}, []color.RGBA{sky, navy}, []int{1}},
+	}
+	for _, tt := range tests {
+		if got := MatchByLightness(tt.source, tt.target); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: MatchByLightness = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	source := []color.RGBA{black, red, white}
+	target := []color.RGBA{cream, navy, sky}
+	// each source swatch moves to the target swatch of the same lightness rank
+	want := map[color.RGBA]color.RGBA{black: navy, red: sky, white: cream}
+	i := image.NewNRGBA(image.Rect(10, 20, 13, 23))
+	for x, c := range source {
+		// opaque, translucent and fully transparent pixels of each swatch
+		for y, a := range []uint8{255, 128, 0} {
+			i.SetNRGBA(10+x, 20+y, color.NRGBA{c.R, c.G, c.B, a})
+		}
+	}
+	for _, s := range []ColorSpace{ColorSpaceRGB, ColorSpaceHSL, ColorSpaceLab} {
+		out, err := Transfer(source, target, i, s, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := out.Bounds(); b != image.Rect(0, 0, 3, 3) {
+			t.Fatalf("%v: bounds %v, want %v", s, b, image.Rect(0, 0, 3, 3))
+		}
+		for x, c := range source {
+			for y, a := range []uint8{255, 128, 0} {
+				w := want[c]
+				if a == 0 {
+					w = color.RGBA{}
+				}
+				if got := out.NRGBAAt(x, y); got != (color.NRGBA{w.R, w.G, w.B, a}) {
+					t.Errorf("%v: %v with alpha %d became %v, want %v with alpha %d", s, c, a, got, w, a)
+				}
+			}
+		}
+	}
+}
+
+func TestTransferShift(t *testing.T) {
+	// colors between the swatches are shifted by a mix of the swatch shifts (+40 and -100),
+	// dominated by the shift of the nearest swatch
+	source := []color.RGBA{black, white}
+	target := []color.RGBA{{40, 40, 40, 255}, {155, 155, 155, 255}}
+	tests := []struct {
+		grey     uint8
+		min, max uint8
+	}{
+		{10, 45, 50},
+		{128, 92, 102},
+		{245, 145, 150},
+	}
+	i := image.NewRGBA(image.Rect(0, 0, len(tests), 1))
+	for x, tt := range tests {
+		i.SetRGBA(x, 0, color.RGBA{tt.grey, tt.grey, tt.grey, 255})
+	}
+	out, err := Transfer(source, target, i, ColorSpaceRGB, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, tt := range tests {
+		if got := out.NRGBAAt(x, 0); got.R < tt.min || got.R > tt.max || got.R != got.G || got.G != got.B {
+			t.Errorf("grey %d became %v, want a grey in [%d, %d]", tt.grey, got, tt.min, tt.max)
+		}
+	}
+}
+func TestTransferEmptyPalette(t *testing.T) {
+	i := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	if _, err := Transfer(nil, []color.RGBA{white}, i, ColorSpaceLab, 2); err == nil {
+		t.Error("Transfer with an empty source palette succeeded")
+	}
+	if _, err := Transfer([]color.RGBA{white}, nil, i, ColorSpaceLab, 2); err == nil {
+		t.Error("Transfer with an empty target palette succeeded")
+	}
+}
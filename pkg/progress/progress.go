@@ -0,0 +1,203 @@
+// Package progress tracks and reports the progress of batch runs over many images.
+//
+// On a terminal, a status line with counts, throughput and an ETA is redrawn in place below
+// the log output; otherwise, the same status is logged periodically (using log/slog).
+package progress
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Display modes
+const (
+	ModeAuto     = "auto"
+	ModeTerminal = "tty"
+	ModeLog      = "log"
+	ModeOff      = "off"
+)
+
+// Modes are the supported display modes
+var Modes = []string{ModeAuto, ModeTerminal, ModeLog, ModeOff}
+
+// Outcome is the result of processing one image
+type Outcome int
+
+// Outcomes
+const (
+	// Extracted means a palette was extracted from the image
+	Extracted Outcome = iota
+	// Cached means the palette was read from the palette cache
+	Cached
+	// Loaded means the palette was read from an existing palette JSON file
+	Loaded
+	// Failed means the image could not be processed
+	Failed
+	outcomes
+)
+
+// Tracker counts completed images. It is safe for concurrent use.
+type Tracker struct {
+	// Interval is the time between status updates (default 200ms on a terminal, 10s otherwise)
+	Interval time.Duration
+
+	mu       sync.Mutex
+	out      io.Writer
+	mode     string
+	terminal bool
+	start    time.Time
+	end      time.Time
+	total    int
+	counts   [outcomes]int
+	pixels   int64
+	drawn    bool
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// IsTerminal returns whether `f` is a terminal (character device)
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// New returns a tracker writing to `f` in the given display mode.
+// ModeAuto draws a status line if `f` is a terminal, and logs the status otherwise.
+// ModeOff only counts, for the Summary.
+func New(f *os.File, mode string) *Tracker {
+	t := &Tracker{out: f, mode: mode, start: time.Now()}
+	switch mode {
+	case ModeTerminal:
+		t.terminal = true
+	case ModeAuto:
+		t.terminal = IsTerminal(f)
+	}
+	return t
+}
+
+// Start begins reporting the status every `t.Interval` until Stop is called
+func (t *Tracker) Start() {
+	if t.mode == ModeOff {
+		return
+	}
+	if t.Interval <= 0 {
+		t.Interval = 10 * time.Second
+		if t.terminal {
+			t.Interval = 200 * time.Millisecond
+		}
+	}
+	t.stop, t.stopped = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(t.stopped)
+		ticker := time.NewTicker(t.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.report()
+			}
+		}
+	}()
+}
+
+// Stop stops reporting and clears the status line. Calls after the first have no effect.
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() {
+		if t.stop != nil {
+			close(t.stop)
+			<-t.stopped
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.clear()
+		t.end = time.Now()
+	})
+}
+
+// Add adds `n` images to the number of images to be processed
+func (t *Tracker) Add(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total += n
+}
+
+// Done records that an image of `pixels` pixels has been processed with the given outcome
+func (t *Tracker) Done(o Outcome, pixels int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[o]++
+	if o == Extracted {
+		t.pixels += int64(pixels)
+	}
+}
+
+// Write writes log output above the status line. Use it as the output of the commands' logger.
+func (t *Tracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	redraw := t.drawn
+	t.clear()
+	n, err := t.out.Write(p)
+	if redraw {
+		t.draw()
+	}
+	return n, err
+}
+
+// Stats returns the tracker's counts so far
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats()
+}
+
+func (t *Tracker) stats() Stats {
+	s := Stats{
+		Total:     t.total,
+		Extracted: t.counts[Extracted],
+		Cached:    t.counts[Cached],
+		Loaded:    t.counts[Loaded],
+		Failed:    t.counts[Failed],
+		Pixels:    t.pixels,
+		Elapsed:   time.Since(t.start),
+		Finished:  !t.end.IsZero(),
+	}
+	if s.Finished {
+		s.Elapsed = t.end.Sub(t.start)
+	}
+	return s
+}
+
+func (t *Tracker) report() {
+	t.mu.Lock()
+	if t.terminal {
+		defer t.mu.Unlock()
+		t.draw()
+		return
+	}
+	s := t.stats()
+	// the log output may be this tracker
+	t.mu.Unlock()
+	slog.Info("progress", "stats", s)
+}
+
+// draw redraws the status line in place; the cursor stays at its end
+func (t *Tracker) draw() {
+	fmt.Fprintf(t.out, "\r\x1b[K%s", t.stats())
+	t.drawn = true
+}
+
+// clear erases the status line
+func (t *Tracker) clear() {
+	if t.drawn {
+		io.WriteString(t.out, "\r\x1b[K")
+		t.drawn = false
+	}
+}
@@ -0,0 +1,110 @@
+package progress
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsETA(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats Stats
+		want  time.Duration
+	}{
+		{"running", Stats{Total: 6, Extracted: 1, Failed: 1, Elapsed: 10 * time.Second}, 20 * time.Second},
+		{"nothing done", Stats{Total: 6, Elapsed: 10 * time.Second}, 0},
+		{"finished", Stats{Total: 6, Extracted: 2, Elapsed: 10 * time.Second, Finished: true}, 0},
+		{"all done", Stats{Total: 2, Extracted: 2, Elapsed: 10 * time.Second}, 0},
+		// more images may be done than known to be queued while paths are still being listed
+		{"more done than total", Stats{Total: 1, Extracted: 2, Elapsed: 10 * time.Second}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.ETA(); got != tt.want {
+			t.Errorf("%s: ETA() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatsString(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats Stats
+		want  string
+	}{
+		{
+			"running",
+			Stats{Total: 4, Extracted: 1, Failed: 1, Pixels: 2000000, Elapsed: 2 * time.Second},
+			"2/4 images (50.0%): 1 extracted, 0 cached, 1 failed, 1.0 images/s, 1.0 MP/s, ETA 2s",
+		},
+		{
+			"nothing queued",
+			Stats{},
+			"0/0 images: 0 extracted, 0 cached, 0 failed",
+		},
+		{
+			"more done than total",
+			Stats{Total: 1, Extracted: 2, Elapsed: time.Second},
+			"2/1 images (200.0%): 2 extracted, 0 cached, 0 failed, 2.0 images/s, 0.0 MP/s",
+		},
+		{
+			"finished",
+			Stats{Total: 5, Extracted: 3, Cached: 1, Loaded: 1, Pixels: 500000, Elapsed: 2*time.Second + 345*time.Microsecond, Finished: true},
+			"5 images in 2s: 3 extracted, 1 cached, 1 loaded, 0 failed, 2.5 images/s, 0.2 MP/s",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.String(); got != tt.want {
+			t.Errorf("%s: String() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrackerWrite(t *testing.T) {
+	var b bytes.Buffer
+	tracker := &Tracker{out: &b, mode: ModeTerminal, terminal: true, start: time.Now()}
+	tracker.Add(2)
+
+	// without a status line, log output is written as is
+	tracker.Write([]byte("first\n"))
+	if got, want := b.String(), "first\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+
+	// the status line is cleared before log output and redrawn after it
+	b.Reset()
+	tracker.report()
+	tracker.Write([]byte("second\n"))
+	status := "0/2 images (0.0%): 0 extracted, 0 cached, 0 failed"
+	if got, want := b.String(), "\r\x1b[K"+status+"\r\x1b[K"+"second\n"+"\r\x1b[K"+status; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+
+	// once stopped, the status line is cleared and not redrawn
+	b.Reset()
+	tracker.Stop()
+	tracker.Write([]byte("third\n"))
+	if got, want := b.String(), "\r\x1b[K"+"third\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
+
+// TestTrackerLog checks that the status is logged rather than drawn when not on a terminal
+func TestTrackerLog(t *testing.T) {
+	var b, logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	tracker := &Tracker{out: &b, mode: ModeLog, start: time.Now()}
+	tracker.Add(1)
+	tracker.report()
+	tracker.Write([]byte("log\n"))
+	tracker.Stop()
+	if got, want := b.String(), "log\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+	if !strings.Contains(logs.String(), "msg=progress stats.total=1 stats.done=0") {
+		t.Errorf("logged %q, want the status", logs.String())
+	}
+}
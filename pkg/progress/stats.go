@@ -0,0 +1,91 @@
+package progress
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Stats are the counts of a Tracker at one point in time
+type Stats struct {
+	// Total is the number of images to be processed, as far as known
+	Total     int
+	Extracted int
+	Cached    int
+	Loaded    int
+	Failed    int
+	// Pixels is the number of pixels of the extracted images
+	Pixels  int64
+	Elapsed time.Duration
+	// Finished is whether the tracker has been stopped
+	Finished bool
+}
+
+// Done returns the number of processed images
+func (s Stats) Done() int {
+	return s.Extracted + s.Cached + s.Loaded + s.Failed
+}
+
+// ETA estimates the remaining time from the average time per image so far, or returns 0 if unknown
+func (s Stats) ETA() time.Duration {
+	done := s.Done()
+	if s.Finished || done == 0 || s.Total <= done {
+		return 0
+	}
+	return time.Duration(float64(s.Elapsed) / float64(done) * float64(s.Total-done))
+}
+
+func (s Stats) rates() (images, megapixels float64) {
+	seconds := s.Elapsed.Seconds()
+	if seconds <= 0 {
+		return 0, 0
+	}
+	return float64(s.Done()) / seconds, float64(s.Pixels) / 1e6 / seconds
+}
+
+// String returns a one-line status, or a summary once finished
+func (s Stats) String() string {
+	var b strings.Builder
+	done := s.Done()
+	if s.Finished {
+		fmt.Fprintf(&b, "%d images in %s", done, s.Elapsed.Round(time.Millisecond))
+	} else {
+		fmt.Fprintf(&b, "%d/%d images", done, s.Total)
+		if s.Total > 0 {
+			fmt.Fprintf(&b, " (%.1f%%)", 100*float64(done)/float64(s.Total))
+		}
+	}
+	fmt.Fprintf(&b, ": %d extracted, %d cached", s.Extracted, s.Cached)
+	if s.Loaded > 0 {
+		fmt.Fprintf(&b, ", %d loaded", s.Loaded)
+	}
+	fmt.Fprintf(&b, ", %d failed", s.Failed)
+	if images, megapixels := s.rates(); images > 0 {
+		fmt.Fprintf(&b, ", %.1f images/s, %.1f MP/s", images, megapixels)
+	}
+	if eta := s.ETA(); eta > 0 {
+		fmt.Fprintf(&b, ", ETA %s", eta.Round(time.Second))
+	}
+	return b.String()
+}
+
+// LogValue returns the stats as a group of log attributes
+func (s Stats) LogValue() slog.Value {
+	images, megapixels := s.rates()
+	attrs := []slog.Attr{
+		slog.Int("total", s.Total),
+		slog.Int("done", s.Done()),
+		slog.Int("extracted", s.Extracted),
+		slog.Int("cached", s.Cached),
+		slog.Int("loaded", s.Loaded),
+		slog.Int("failed", s.Failed),
+		slog.Float64("seconds", s.Elapsed.Seconds()),
+		slog.Float64("imagesPerSecond", images),
+		slog.Float64("megapixelsPerSecond", megapixels),
+	}
+	if eta := s.ETA(); eta > 0 {
+		attrs = append(attrs, slog.Float64("etaSeconds", eta.Seconds()))
+	}
+	return slog.GroupValue(attrs...)
+}
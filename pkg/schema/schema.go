@@ -0,0 +1,190 @@
+// Package schema defines the versioned JSON documents written and read by the tools.
+//
+// Version 0 is the unversioned format of earlier releases: palette documents with only
+// `path`, `palette` (and `frames`), and cluster summaries with only `centroids` and `mapping`.
+// ReadPalette migrates version 0 palette documents to the current version.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+)
+
+// Version is the current schema version
+const Version = 1
+
+// Tool identifies the program that wrote a document
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Options are the extraction or clustering options a document was made with
+type Options struct {
+	K          int    `json:"k"`
+	Algorithm  string `json:"algorithm,omitempty"`
+	ColorSpace string `json:"colorSpace,omitempty"`
+	Seed       int64  `json:"seed"`
+	Sample     int    `json:"sample,omitempty"`
+}
+
+// NewOptions returns the schema options for palette options
+func NewOptions(o palette.Options) Options {
+	return Options{
+		K:          o.K,
+		Algorithm:  o.Algorithm,
+		ColorSpace: o.ColorSpace.String(),
+		Seed:       o.Seed,
+		Sample:     o.Sample,
+	}
+}
+
+// Palette is the palette of one image
+type Palette struct {
+	Version int   `json:"version"`
+	Tool    *Tool `json:"tool,omitempty"`
+	Options
+	Path    string          `json:"path"`
+	Width   int             `json:"width,omitempty"`
+	Height  int             `json:"height,omitempty"`
+	Palette palette.Palette `json:"palette"`
+	// Coverage holds the fraction of the image's pixels nearest to each palette color
+	Coverage []float64         `json:"coverage,omitempty"`
+	Frames   []palette.Palette `json:"frames,omitempty"`
+}
+
+// NewPalette returns the palette document for an extraction result
+func NewPalette(tool *Tool, path string, bounds image.Rectangle, r *palette.ExtractResult) *Palette {
+	return &Palette{
+		Version:  Version,
+		Tool:     tool,
+		Options:  NewOptions(r.Options),
+		Path:     path,
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		Palette:  r.Palette,
+		Coverage: r.Weights,
+	}
+}
+
+// Validate checks that the document is consistent
+func (p *Palette) Validate() error {
+	switch {
+	case p.Version < 0 || p.Version > Version:
+		return fmt.Errorf("unsupported schema version %d (supported: up to %d)", p.Version, Version)
+	case len(p.Palette) == 0:
+		return errors.New("empty palette")
+	case p.K != len(p.Palette):
+		return fmt.Errorf("k is %d, but the palette has %d colors", p.K, len(p.Palette))
+	case p.Coverage != nil && len(p.Coverage) != len(p.Palette):
+		return fmt.Errorf("coverage has %d entries, but the palette has %d colors", len(p.Coverage), len(p.Palette))
+	case p.Width < 0 || p.Height < 0:
+		return fmt.Errorf("invalid dimensions %dx%d", p.Width, p.Height)
+	}
+	for i, f := range p.Frames {
+		if len(f) != len(p.Palette) {
+			return fmt.Errorf("frame %d has %d colors, but the palette has %d", i, len(f), len(p.Palette))
+		}
+	}
+	return nil
+}
+
+// migrate upgrades an older document to the current version
+func (p *Palette) migrate() {
+	if p.Version == 0 {
+		p.K = len(p.Palette)
+	}
+	p.Version = Version
+}
+
+// ReadPalette reads, migrates and validates a palette document
+func ReadPalette(r io.Reader) (*Palette, error) {
+	var p Palette
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Version < 0 || p.Version > Version {
+		return nil, fmt.Errorf("unsupported schema version %d (supported: up to %d)", p.Version, Version)
+	}
+	p.migrate()
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Clustering is the result of clustering images by their palettes
+type Clustering struct {
+	Version int   `json:"version"`
+	Tool    *Tool `json:"tool,omitempty"`
+	// N is the number of clusters
+	N int `json:"n"`
+	// Palette holds the options used to extract the images' palettes
+	Palette Options `json:"palette"`
+	// Cluster holds the options used to cluster the palettes
+	Cluster   Options           `json:"cluster"`
+	Centroids []palette.Palette `json:"centroids"`
+	Sizes     []int             `json:"sizes"`
+	// Mapping maps each image path to its cluster
+	Mapping map[string]int `json:"mapping"`
+	// Failed lists the images that could not be processed and are missing from the mapping
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Validate checks that the document is consistent
+func (c *Clustering) Validate() error {
+	switch {
+	case c.Version < 0 || c.Version > Version:
+		return fmt.Errorf("unsupported schema version %d (supported: up to %d)", c.Version, Version)
+	case c.Sizes != nil && len(c.Sizes) != len(c.Centroids):
+		return fmt.Errorf("%d cluster sizes for %d centroids", len(c.Sizes), len(c.Centroids))
+	}
+	for path, label := range c.Mapping {
+		if label < 0 || label >= len(c.Centroids) {
+			return fmt.Errorf("%s: cluster %d out of range", path, label)
+		}
+	}
+	return nil
+}
+
+// Error records an image that could not be processed, or an output that could not be written.
+// The tools write one per line to the file given by -out-errors-json.
+type Error struct {
+	Version int   `json:"version"`
+	Tool    *Tool `json:"tool,omitempty"`
+	// Path is the image the error is about, if any
+	Path string `json:"path,omitempty"`
+	// Stage is the processing stage that failed: read, decode, extract, cluster, load, template, write or shell
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// Sort sorts the palette (with its coverage) and each frame's palette by `order`
+func (p *Palette) Sort(order palette.Order) {
+	if len(p.Coverage) == len(p.Palette) {
+		sort.Stable(byOrder{p, order})
+	} else {
+		sort.Stable(p.Palette.By(order))
+	}
+	for _, f := range p.Frames {
+		sort.Stable(f.By(order))
+	}
+}
+
+type byOrder struct {
+	*Palette
+	order palette.Order
+}
+
+func (b byOrder) Len() int           { return len(b.Palette.Palette) }
+func (b byOrder) Less(i, j int) bool { return b.order(b.Palette.Palette[i], b.Palette.Palette[j]) }
+func (b byOrder) Swap(i, j int) {
+	b.Palette.Palette.Swap(i, j)
+	b.Coverage[i], b.Coverage[j] = b.Coverage[j], b.Coverage[i]
+}
@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+)
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+func TestReadPaletteMigrate(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want Palette
+	}{
+		{
+			"version 0",
+			`{"path":"a.png","palette":["#ff0000","#00ff00"]}`,
+			Palette{Version: Version, Options: Options{K: 2}, Path: "a.png", Palette: palette.Palette{red, green}},
+		},
+		{
+			"version 0 with frames",
+			`{"path":"a.gif","palette":["#ff0000","#00ff00"],"frames":[["#00ff00","#ff0000"],["#ff0000","#0000ff"]]}`,
+			Palette{
+				Version: Version, Options: Options{K: 2}, Path: "a.gif", Palette: palette.Palette{red, green},
+				Frames: []palette.Palette{{green, red}, {red, blue}},
+			},
+		},
+		{
+			"current version",
+			`{"version":1,"k":3,"seed":4,"path":"b.png","width":10,"height":20,"palette":["#ff0000","#00ff00","#0000ff"],"coverage":[0.5,0.25,0.25]}`,
+			Palette{
+				Version: Version, Options: Options{K: 3, Seed: 4}, Path: "b.png", Width: 10, Height: 20,
+				Palette: palette.Palette{red, green, blue}, Coverage: []float64{0.5, 0.25, 0.25},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ReadPalette(strings.NewReader(tt.doc))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s: read %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestReadPaletteInvalid(t *testing.T) {
+	docs := map[string]string{
+		"newer version":     `{"version":2,"k":1,"path":"a.png","palette":["#ff0000"]}`,
+		"negative version":  `{"version":-1,"k":1,"path":"a.png","palette":["#ff0000"]}`,
+		"empty palette":     `{"version":1,"k":0,"path":"a.png","palette":[]}`,
+		"version 0 empty":   `{"path":"a.png"}`,
+		"k mismatch":        `{"version":1,"k":2,"path":"a.png","palette":["#ff0000"]}`,
+		"coverage mismatch": `{"version":1,"k":1,"path":"a.png","palette":["#ff0000"],"coverage":[0.5,0.5]}`,
+		"dimensions":        `{"version":1,"k":1,"path":"a.png","width":-1,"palette":["#ff0000"]}`,
+		"frame mismatch":    `{"path":"a.gif","palette":["#ff0000"],"frames":[["#ff0000","#00ff00"]]}`,
+		"invalid color":     `{"version":1,"k":1,"path":"a.png","palette":["#gg0000"]}`,
+		"invalid JSON":      `{"version":1,`,
+	}
+	for name, doc := range docs {
+		if p, err := ReadPalette(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: ReadPalette = %+v, want an error", name, *p)
+		}
+	}
+}
+
+func TestPaletteRoundTrip(t *testing.T) {
+	doc := &Palette{
+		Version: Version,
+		Tool:    &Tool{Name: "extract-palette", Version: "1.2.3"},
+		Options: NewOptions(palette.Options{K: 3, Algorithm: palette.AlgorithmKmeansPP, ColorSpace: palette.ColorSpaceLab, Seed: 7, Sample: 1000}),
+		Path:    "photos/a.jpg", Width: 640, Height: 480,
+		Palette:  palette.Palette{blue, green, red},
+		Coverage: []float64{0.5, 0.3, 0.2},
+		Frames:   []palette.Palette{{blue, green, red}},
+	}
+	if err := doc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(doc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPalette(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, doc) {
+		t.Errorf("read %+v, want %+v", *got, *doc)
+	}
+	if doc.ColorSpace != "lab" || doc.Algorithm != palette.AlgorithmKmeansPP {
+		t.Errorf("options %+v, want the names of the algorithm and color space", doc.Options)
+	}
+}
+
+func TestClusteringValidate(t *testing.T) {
+	valid := Clustering{
+		Version:   Version,
+		N:         2,
+		Centroids: []palette.Palette{{red}, {blue}},
+		Sizes:     []int{1, 1},
+		Mapping:   map[string]int{"a.png": 0, "b.png": 1},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid clustering: %v", err)
+	}
+	invalid := map[string]func(c *Clustering){
+		"newer version": func(c *Clustering) { c.Version = Version + 1 },
+		"sizes":         func(c *Clustering) { c.Sizes = []int{2} },
+		"label":         func(c *Clustering) { c.Mapping = map[string]int{"a.png": 2} },
+	}
+	for name, change := range invalid {
+		c := valid
+		change(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded", name)
+		}
+	}
+}
+
+func TestPaletteSort(t *testing.T) {
+	doc := &Palette{
+		Palette:  palette.Palette{{250, 250, 250, 255}, {10, 10, 10, 255}, {120, 120, 120, 255}},
+		Coverage: []float64{0.1, 0.2, 0.7},
+		Frames:   []palette.Palette{{{120, 120, 120, 255}, {250, 250, 250, 255}, {10, 10, 10, 255}}},
+	}
+	doc.Sort(palette.OrderLHS)
+	want := palette.Palette{{10, 10, 10, 255}, {120, 120, 120, 255}, {250, 250, 250, 255}}
+	if !reflect.DeepEqual(doc.Palette, want) || !reflect.DeepEqual(doc.Frames[0], want) {
+		t.Errorf("sorted to %v and frame %v, want %v", doc.Palette.Hex(), doc.Frames[0].Hex(), want.Hex())
+	}
+	// the coverage is sorted with its colors
+	if want := []float64{0.2, 0.7, 0.1}; !reflect.DeepEqual(doc.Coverage, want) {
+		t.Errorf("coverage %v, want %v", doc.Coverage, want)
+	}
+}
@@ -0,0 +1,90 @@
+// Package status counts the errors of a command's run and chooses its exit code.
+//
+// All commands use the same exit codes: OK if every input was processed and every output
+// written, Partial if some were not, Usage for invalid flags or arguments, and Failure if
+// no input could be processed or the run could not be completed.
+package status
+
+import (
+	"log/slog"
+	"os"
+	"sync/atomic"
+
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+)
+
+// Exit codes
+const (
+	// OK means every input was processed and every output written
+	OK = 0
+	// Partial means some inputs could not be processed or some outputs not written
+	Partial = 1
+	// Usage means invalid flags or arguments
+	Usage = 2
+	// Failure means no input could be processed, or the run could not be completed
+	Failure = 3
+)
+
+// Errors counts the errors reported during a run, and the inputs that failed.
+// It is safe for concurrent use.
+type Errors struct {
+	count  int64
+	failed int64
+}
+
+// Report logs that `path` could not be processed or an output for it could not be written,
+// and returns the number of errors reported so far. `path` is empty for errors about no input
+// in particular. Errors without a stage (see logging.WithStage) are reported at `stage`.
+func (e *Errors) Report(path, stage string, err error) int64 {
+	args := []interface{}{"stage", logging.StageOf(err, stage), "error", err}
+	if path != "" {
+		args = append([]interface{}{"path", path}, args...)
+	}
+	slog.Error("failed", args...)
+	return atomic.AddInt64(&e.count, 1)
+}
+
+// Fail reports that the input `path` could not be processed at all
+func (e *Errors) Fail(path, stage string, err error) {
+	e.Report(path, stage, err)
+	atomic.AddInt64(&e.failed, 1)
+}
+
+// Count returns the number of errors reported
+func (e *Errors) Count() int64 {
+	return atomic.LoadInt64(&e.count)
+}
+
+// Failed returns the number of inputs that failed
+func (e *Errors) Failed() int64 {
+	return atomic.LoadInt64(&e.failed)
+}
+
+// ExitCode returns the exit code for a run over `inputs` inputs: Failure if every input failed,
+// Partial if there were other errors, and OK otherwise
+func (e *Errors) ExitCode(inputs int) int {
+	n := e.Count()
+	switch {
+	case n > 0 && e.Failed() >= int64(inputs):
+		return Failure
+	case n > 0:
+		return Partial
+	}
+	return OK
+}
+
+// Fatal logs an error that ends the run, about `path` unless it is empty, and exits with Failure
+func Fatal(path string, err error) {
+	args := []interface{}{"error", err}
+	if path != "" {
+		args = append([]interface{}{"path", path}, args...)
+	}
+	slog.Error("fatal", args...)
+	os.Exit(Failure)
+}
+
+// UsageError logs invalid flags or arguments and exits with Usage
+func UsageError(err error) {
+	slog.Error("invalid usage", "error", err)
+	os.Exit(Usage)
+}
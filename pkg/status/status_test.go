@@ -0,0 +1,48 @@
+package status
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sgreben/image-palette-tools/pkg/logging"
+)
+
+func TestErrors(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	var e Errors
+	if got := e.ExitCode(2); got != OK {
+		t.Errorf("ExitCode without errors = %d, want %d", got, OK)
+	}
+	if n := e.Report("a.png", logging.StageWrite, errors.New("disk full")); n != 1 {
+		t.Errorf("Report returned %d, want 1", n)
+	}
+	if got := e.ExitCode(2); got != Partial {
+		t.Errorf("ExitCode after an output error = %d, want %d", got, Partial)
+	}
+	e.Fail("b.png", logging.StageRead, logging.WithStage(logging.StageDecode, errors.New("bad header")))
+	if got := e.ExitCode(2); got != Partial {
+		t.Errorf("ExitCode with 1 of 2 inputs failed = %d, want %d", got, Partial)
+	}
+	e.Fail("a.png", logging.StageExtract, errors.New("empty image"))
+	if got := e.ExitCode(2); got != Failure {
+		t.Errorf("ExitCode with 2 of 2 inputs failed = %d, want %d", got, Failure)
+	}
+	if e.Count() != 3 || e.Failed() != 2 {
+		t.Errorf("%d errors and %d failed inputs, want 3 and 2", e.Count(), e.Failed())
+	}
+	// errors are logged at their own stage, if they have one
+	for _, want := range []string{
+		`msg=failed path=a.png stage=write error="disk full"`,
+		`msg=failed path=b.png stage=decode error="bad header"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log %q does not contain %q", logs.String(), want)
+		}
+	}
+}
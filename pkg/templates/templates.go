@@ -0,0 +1,28 @@
+// Package templates provides the functions available in the commands' path and command templates
+// (the `-out-*`, `-in-json` and `-out-shell` flags).
+package templates
+
+import (
+	"path/filepath"
+	"text/template"
+
+	"github.com/sgreben/image-palette-tools/pkg/palette"
+)
+
+// Funcs are the functions available in templates
+var Funcs = template.FuncMap{
+	"abs":      func(s string) (string, error) { return filepath.Abs(s) },
+	"basename": func(s string) string { return filepath.Base(s) },
+	"dirname":  func(s string) string { return filepath.Dir(s) },
+	"ext":      func(s string) string { return filepath.Ext(s) },
+	"html":     palette.Hex,
+	"rgb":      palette.FormatRGB,
+	"hsl":      palette.FormatHSL,
+	"oklch":    palette.FormatOKLCH,
+}
+
+// New returns a root template for template flags, with Funcs defined.
+// Referring to a missing key is an error rather than producing "<no value>".
+func New() *template.Template {
+	return template.New("").Funcs(Funcs).Option("missingkey=error")
+}